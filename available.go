@@ -3,6 +3,7 @@ package pm
 import (
 	"fmt"
 	"net/url"
+	"regexp"
 	"sort"
 	"strings"
 
@@ -25,10 +26,9 @@ type Version string
 // Versions is a slice of Version ... with sorting!
 type Versions []Version
 
-// TODO (sm): make this semver sort?
 func (v Versions) Len() int           { return len(v) }
 func (v Versions) Swap(a, b int)      { v[a], v[b] = v[b], v[a] }
-func (v Versions) Less(a, b int) bool { return v[a] < v[b] }
+func (v Versions) Less(a, b int) bool { return v[a].Compare(v[b]) < 0 }
 
 type label struct {
 	n Name
@@ -37,14 +37,13 @@ type label struct {
 
 type labels []label
 
-// TODO (sm): make this semver sort?
 func (n labels) Len() int      { return len(n) }
 func (n labels) Swap(a, b int) { n[a], n[b] = n[b], n[a] }
 func (n labels) Less(a, b int) bool {
 	if n[a].n != n[b].n {
 		return n[a].n < n[b].n
 	}
-	return n[a].v < n[b].v
+	return n[a].v.Compare(n[b].v) < 0
 }
 
 // Available is the structure used to represent the collection of all packages
@@ -155,6 +154,12 @@ func labelForString(s string) (label, error) {
 	return r, nil
 }
 
+// ParseLabel parses a package label of the form name or name@version.
+func ParseLabel(s string) (Name, Version, error) {
+	l, err := labelForString(s)
+	return l.n, l.v, err
+}
+
 // Installable calculates if the packages requested in "in" can be installed.
 func (a Available) Installable(in []string) (Metas, error) {
 	ls := labels{}
@@ -185,3 +190,56 @@ func (a Available) Installable(in []string) (Metas, error) {
 
 	return ms, nil
 }
+
+// Search returns the packages in a whose name or description matches each of
+// terms, which are case-insensitive regular expressions. Packages named
+// exactly by a term come first, then those whose names start with one, then
+// the rest, each in name order. Only the newest version of each package is
+// returned unless all is set.
+func (a Available) Search(terms []string, all bool) (Metas, error) {
+	res := []*regexp.Regexp{}
+	for _, t := range terms {
+		re, err := regexp.Compile("(?i)" + t)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %q", t)
+		}
+		res = append(res, re)
+	}
+
+	rank := func(n Name) int {
+		r := 2
+		for _, t := range terms {
+			switch {
+			case strings.EqualFold(string(n), t):
+				return 0
+			case strings.HasPrefix(strings.ToLower(string(n)), strings.ToLower(t)):
+				r = 1
+			}
+		}
+		return r
+	}
+	match := func(m Meta) bool {
+		for _, re := range res {
+			if !re.MatchString(string(m.Name)) && !re.MatchString(m.Description) {
+				return false
+			}
+		}
+		return true
+	}
+
+	r := Metas{}
+	for m := range a.Traverse() {
+		if !all {
+			if n, _ := a.Get(m.Name, ""); n.Version != m.Version {
+				continue
+			}
+		}
+		if match(m) {
+			r = append(r, m)
+		}
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		return rank(r[i].Name) < rank(r[j].Name)
+	})
+	return r, nil
+}
@@ -55,6 +55,41 @@ func TestAvailableAdd(t *testing.T) {
 	}
 }
 
+func TestAvailableGet(t *testing.T) {
+	a := Available{}
+	for _, v := range []Version{"1.2.0", "1.9.0", "1.10.0", "1.11.0-rc1"} {
+		if err := a.Add(Meta{Name: "foo", Version: v, Description: "test"}); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+
+	m, err := a.Get("foo", "")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got, want := m.Version, Version("1.11.0-rc1"); got != want {
+		t.Fatalf("newest: got %v, want %v", got, want)
+	}
+	if err := a.Add(Meta{Name: "foo", Version: "1.11.0", Description: "test"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	ms, err := a.Installable([]string{"foo"})
+	if err != nil {
+		t.Fatalf("installable: %v", err)
+	}
+	if got, want := ms[0].Version, Version("1.11.0"); got != want {
+		t.Fatalf("installable: got %v, want %v", got, want)
+	}
+	delete(a["foo"], "1.11.0")
+	delete(a["foo"], "1.11.0-rc1")
+	if m, err = a.Get("foo", ""); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got, want := m.Version, Version("1.10.0"); got != want {
+		t.Fatalf("newest: got %v, want %v", got, want)
+	}
+}
+
 func TestAvailableUpdate(t *testing.T) {
 	a := Available{}
 	if err := a.Add(Meta{Name: "a", Version: "v1.0.0", Description: "test"}); err != nil {
@@ -103,3 +138,60 @@ func TestAvailableUpdate(t *testing.T) {
 		t.Fatalf("last in didn't override")
 	}
 }
+
+func TestAvailableSearch(t *testing.T) {
+	a := Available{}
+	for _, m := range []Meta{
+		{Name: "barfoo", Version: "1.0.0", Description: "ends in foo"},
+		{Name: "bar", Version: "1.0.0", Description: "a FOO frobnicator"},
+		{Name: "foo", Version: "1.0.0", Description: "the original"},
+		{Name: "foo", Version: "1.1.0", Description: "the original"},
+		{Name: "foobar", Version: "2.0.0", Description: "foo, with bar"},
+		{Name: "baz", Version: "1.0.0", Description: "unrelated"},
+		{Name: "num", Version: "1.9.0", Description: "numbered"},
+		{Name: "num", Version: "1.10.0", Description: "numbered"},
+	} {
+		if err := a.Add(m); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+
+	tests := []struct {
+		label string
+		terms []string
+		all   bool
+		want  []string
+	}{
+		{"ranked", []string{"foo"}, false, []string{"foo@1.1.0", "foobar@2.0.0", "bar@1.0.0", "barfoo@1.0.0"}},
+		{"all versions", []string{"foo"}, true, []string{"foo@1.0.0", "foo@1.1.0", "foobar@2.0.0", "bar@1.0.0", "barfoo@1.0.0"}},
+		{"every term", []string{"foo", "bar"}, false, []string{"bar@1.0.0", "barfoo@1.0.0", "foobar@2.0.0"}},
+		{"regexp", []string{"^ba[rz]$"}, false, []string{"bar@1.0.0", "baz@1.0.0"}},
+		{"newest", []string{"^num$"}, false, []string{"num@1.10.0"}},
+		{"numeric order", []string{"^num$"}, true, []string{"num@1.9.0", "num@1.10.0"}},
+		{"no match", []string{"qux"}, false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			ms, err := a.Search(test.terms, test.all)
+			if err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			got := []string{}
+			for _, m := range ms {
+				got = append(got, string(m.Name)+"@"+string(m.Version))
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+
+	if _, err := a.Search([]string{"("}, false); err == nil {
+		t.Fatalf("did not detect bad regexp")
+	}
+}
@@ -5,12 +5,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
 	"mcquay.me/fs"
+	"mcquay.me/pm"
 	"mcquay.me/pm/db"
 	"mcquay.me/pm/keyring"
 	"mcquay.me/pm/pkg"
+	"mcquay.me/pm/repo"
 )
 
 // Version stores the current version, and is updated at build time.
@@ -20,24 +26,47 @@ const usage = `pm: simple, cross-platform system package manager
 
 subcommands:
   available  (av)  -- print out all installable packages
+  cache            -- manage downloaded packages
   environ    (env) -- print environment information
+  hold             -- skip a package, or all but one of its versions, on install
+  info             -- show everything known about a package
   install    (in)  -- install packages
   keyring    (key) -- interact with pm's OpenPGP keyring
   ls               -- list installed packages
   package    (pkg) -- create packages
+  pin              -- only source a package from the given remote
+  pins             -- list pinned and held packages
   pull             -- fetch all available packages from all configured remotes
   remote           -- configure remote pmd servers
+  repo             -- manage static package repositories
   rm               -- remove packages
+  search           -- find available packages by name or description
+  unhold           -- release a held package
+  unpin            -- source a package from any remote
   version    (v)   -- print version information
+
+options:
+  --output json|text|tsv -- format of listings (default $PM_OUTPUT, or text)
+`
+
+const cacheUsage = `pm cache: manage downloaded packages
+
+subcommands:
+  clean            --  remove all cached packages
+  ls               --  list cached packages
+  prune            --  keep only the newest versions of each cached package
 `
 
 const keyUsage = `pm keyring: interact with pm's OpenPGP keyring
 
 subcommands:
-  create      (c)  --  create a fresh keypair
+  create      (c)  --  create a fresh OpenPGP keypair
+  create-ed25519   --  create a fresh ed25519 keypair
   export      (e)  --  export a public key to stdout
-  import      (i)  --  import a public key from stdin
+  export-secret    --  export a passphrase protected secret key to stdout
+  import      (i)  --  import a public or secret key from stdin
   ls               --  list configured key info
+  passwd           --  change the passphrase protecting a secret key
   rm               --  remove a key from the keyring
   sign        (s)  --  sign a file
   verify      (v)  --  verify a detached signature
@@ -46,18 +75,64 @@ subcommands:
 const pkgUsage = `pm package: generate pm-compatible packages
 
 subcommands:
-  create      (c)  --  create a fresh keypair
+  cosign           --  add a signature to an existing package
+  create      (c)  --  create a package from a directory
+  upload           --  publish a package to a pmd remote
 `
 
 const remoteUsage = `pm remote: configure remote pmd servers
 
 subcommands:
   add         (a)  --  add a URI
+  auth             --  set or list credentials for private remotes
+  disable          --  stop pulling from a remote
+  enable           --  resume pulling from a remote
   ls               --  list configured remotes
-  rm               --  remove a URI
+  mirror           --  add or remove mirrors of a remote
+  policy           --  set or list required package signatures
+  priority         --  set the priority of a remote; smaller values win
+  rename           --  rename a remote
+  rm               --  remove a remote
+  verify           --  check that a remote's mirrors serve its signed index
+
+Remotes may be referred to by name or URI.
+`
+
+const repoUsage = `pm repo: manage static package repositories
+
+subcommands:
+  index            --  verify packages and write a namespace's available.json
+  promote          --  copy a package between namespaces
+  prune            --  remove packages according to a namespace's retention.json
 `
 
 func main() {
+	output := os.Getenv("PM_OUTPUT")
+	if output == "" {
+		output = "text"
+	}
+	// --output may be given anywhere, and is removed before the subcommand
+	// and its arguments are parsed.
+	args := []string{os.Args[0]}
+	for i := 1; i < len(os.Args); i++ {
+		switch a := os.Args[i]; {
+		case a == "--output":
+			if i+1 == len(os.Args) {
+				fatalf("--output requires a format: json, text, or tsv\n")
+			}
+			i++
+			output = os.Args[i]
+		case strings.HasPrefix(a, "--output="):
+			output = strings.TrimPrefix(a, "--output=")
+		default:
+			args = append(args, a)
+		}
+	}
+	os.Args = args
+	if !outputs[output] {
+		fatalf("unknown output format %q; use json, text, or tsv\n", output)
+	}
+
 	if len(os.Args) < 2 {
 		fatalf("pm: missing subcommand\n\n%v", usage)
 	}
@@ -68,11 +143,46 @@ func main() {
 		root = "/usr/local"
 	}
 	signID := os.Getenv("PM_PGP_ID")
+	if j := os.Getenv("PM_JOBS"); j != "" {
+		n, err := strconv.Atoi(j)
+		if err != nil || n < 1 {
+			fatalf("PM_JOBS must be a positive integer, got %q\n", j)
+		}
+		db.Jobs = n
+	}
+	if o := os.Getenv("PM_OS"); o != "" {
+		db.OS = o
+	}
+	if a := os.Getenv("PM_ARCH"); a != "" {
+		db.Arch = a
+	}
+	if k := os.Getenv("PM_KEEP_CACHE"); k != "" {
+		keep, err := strconv.ParseBool(k)
+		if err != nil {
+			fatalf("PM_KEEP_CACHE must be a boolean, got %q\n", k)
+		}
+		pkg.KeepCache = keep
+	}
+	hc, err := httpConfig()
+	if err != nil {
+		fatalf("configuring http: %v\n", err)
+	}
+	if err := db.ConfigureHTTP(hc); err != nil {
+		fatalf("configuring http: %v\n", err)
+	}
+	if terminal.IsTerminal(int(os.Stderr.Fd())) {
+		pkg.Progress = os.Stderr
+	}
 
 	switch cmd {
 	case "env", "environ":
 		fmt.Printf("PM_ROOT=%q\n", root)
 		fmt.Printf("PM_PGP_ID=%q\n", signID)
+		fmt.Printf("PM_JOBS=%d\n", db.Jobs)
+		fmt.Printf("PM_KEEP_CACHE=%v\n", pkg.KeepCache)
+		fmt.Printf("PM_OS=%q\n", db.OS)
+		fmt.Printf("PM_ARCH=%q\n", db.Arch)
+		fmt.Printf("PM_OUTPUT=%q\n", output)
 	case "key", "keyring":
 		if len(os.Args[1:]) < 2 {
 			fatalf("pm keyring: insufficient args\n\nusage: %v", keyUsage)
@@ -80,10 +190,14 @@ func main() {
 		sub, args := os.Args[2], os.Args[3:]
 		switch sub {
 		case "ls":
-			if err := keyring.ListKeys(root, os.Stdout); err != nil {
+			ks, err := keyring.ListKeys(root)
+			if err != nil {
+				fatalf("listing keypair: %v\n", err)
+			}
+			if err := list(output, ks, keyRows(ks)); err != nil {
 				fatalf("listing keypair: %v\n", err)
 			}
-		case "c", "create":
+		case "c", "create", "create-ed25519":
 			var name, email string
 			s := bufio.NewScanner(os.Stdin)
 
@@ -105,26 +219,43 @@ func main() {
 				fatalf("%v\n", err)
 			}
 
-			if err := keyring.NewKeyPair(root, name, email); err != nil {
+			pass, err := keyring.NewPassphrase("passphrase: ")
+			if err != nil {
+				fatalf("reading passphrase: %v\n", err)
+			}
+
+			create := keyring.NewKeyPair
+			if sub == "create-ed25519" {
+				create = keyring.NewEd25519KeyPair
+			}
+			if err := create(root, name, email, pass); err != nil {
 				fatalf("creating keypair: %v\n", err)
 			}
 		case "export", "e":
 			if len(args) != 1 {
-				fatalf("missing email argument\n")
+				fatalf("missing key id\n\nusage: pm key export <id>\n")
+			}
+			id := args[0]
+			if err := keyring.Export(root, os.Stdout, id); err != nil {
+				fatalf("exporting public key for %q: %v\n", id, err)
+			}
+		case "export-secret":
+			if len(args) != 1 {
+				fatalf("missing key id\n\nusage: pm key export-secret <id>\n")
 			}
-			email := args[0]
-			if err := keyring.Export(root, os.Stdout, email); err != nil {
-				fatalf("exporting public key for %q: %v\n", email, err)
+			id := args[0]
+			if err := keyring.ExportSecret(root, os.Stdout, id); err != nil {
+				fatalf("exporting secret key for %q: %v\n", id, err)
 			}
 		case "sign", "s":
 			if signID == "" {
 				fatalf("must set PM_PGP_ID\n")
 			}
-			e, err := keyring.FindSecretEntity(root, signID)
+			e, err := signer(root, signID)
 			if err != nil {
 				fatalf("find secret key: %v\n", err)
 			}
-			if err := keyring.Sign(e, os.Stdin, os.Stdout); err != nil {
+			if err := e.Sign(os.Stdin, os.Stdout); err != nil {
 				fatalf("signing: %v\n", err)
 			}
 		case "verify", "v":
@@ -157,6 +288,26 @@ func main() {
 			if err := keyring.Remove(root, id); err != nil {
 				fatalf("removing key for %q: %v\n", id, err)
 			}
+		case "passwd":
+			if len(args) != 1 {
+				fatalf("missing key id\n\nusage: pm key passwd <id>\n")
+			}
+			id := args[0]
+			old := []byte{}
+			// legacy OpenPGP keys may not be protected yet.
+			if e, err := keyring.FindSecretEntity(root, id); err != nil || keyring.Encrypted(e) {
+				old, err = keyring.Passphrase(fmt.Sprintf("current passphrase for %v: ", id))
+				if err != nil {
+					fatalf("reading passphrase: %v\n", err)
+				}
+			}
+			pass, err := keyring.NewPassphrase("new passphrase: ")
+			if err != nil {
+				fatalf("reading new passphrase: %v\n", err)
+			}
+			if err := keyring.ChangePassphrase(root, id, old, pass); err != nil {
+				fatalf("changing passphrase for %q: %v\n", id, err)
+			}
 		default:
 			fatalf("unknown keyring subcommand: %q\n\nusage: %v", sub, keyUsage)
 		}
@@ -175,13 +326,37 @@ func main() {
 				fatalf("usage: pm package create <directory>\n")
 			}
 			dir := args[0]
-			e, err := keyring.FindSecretEntity(root, signID)
+			e, err := signer(root, signID)
 			if err != nil {
 				fatalf("find secret key: %v\n", err)
 			}
 			if err := pkg.Create(e, dir); err != nil {
 				fatalf("creating package: %v\n", err)
 			}
+		case "cosign":
+			if signID == "" {
+				fatalf("must set PM_PGP_ID\n")
+			}
+			args := os.Args[3:]
+			if len(args) != 1 {
+				fatalf("usage: pm package cosign <file.pkg>\n")
+			}
+			pn := args[0]
+			e, err := signer(root, signID)
+			if err != nil {
+				fatalf("find secret key: %v\n", err)
+			}
+			if err := pkg.Cosign(e, pn); err != nil {
+				fatalf("cosigning package: %v\n", err)
+			}
+		case "upload":
+			args := os.Args[3:]
+			if len(args) != 2 {
+				fatalf("usage: pm package upload <remote> <file.pkg>\n")
+			}
+			if err := pkg.Upload(args[0], args[1], os.Getenv("PM_UPLOAD_TOKEN")); err != nil {
+				fatalf("uploading package: %v\n", err)
+			}
 		default:
 			fatalf("unknown package subcommand: %q\n\nusage: %v", sub, pkgUsage)
 		}
@@ -206,41 +381,333 @@ func main() {
 			}
 		case "rm":
 			if len(args) < 1 {
-				fatalf("missing arg\n\nusage: pm remote rm [<uris>]\n")
+				fatalf("missing arg\n\nusage: pm remote rm [<names or uris>]\n")
 			}
 			if err := db.RemoveRemotes(root, args); err != nil {
 				fatalf("remote remove: %v\n", err)
 			}
 		case "ls":
-			if err := db.ListRemotes(root, os.Stdout); err != nil {
+			rs, err := db.ListRemotes(root)
+			if err != nil {
 				fatalf("list: %v\n", err)
 			}
+			if err := list(output, rs, remoteRows(rs)); err != nil {
+				fatalf("list: %v\n", err)
+			}
+		case "rename":
+			if len(args) != 2 {
+				fatalf("usage: pm remote rename <name> <new name>\n")
+			}
+			if err := db.RenameRemote(root, args[0], args[1]); err != nil {
+				fatalf("remote rename: %v\n", err)
+			}
+		case "priority":
+			if len(args) != 2 {
+				fatalf("usage: pm remote priority <name> <priority>\n")
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				fatalf("priority must be an integer, got %q\n", args[1])
+			}
+			if err := db.SetPriority(root, args[0], n); err != nil {
+				fatalf("remote priority: %v\n", err)
+			}
+		case "enable", "disable":
+			if len(args) != 1 {
+				fatalf("usage: pm remote %v <name>\n", sub)
+			}
+			if err := db.EnableRemote(root, args[0], sub == "enable"); err != nil {
+				fatalf("remote %v: %v\n", sub, err)
+			}
+		case "mirror":
+			if len(args) < 3 || (args[0] != "add" && args[0] != "rm") {
+				fatalf("usage: pm remote mirror <add|rm> <uri> <mirror uris>\n")
+			}
+			var err error
+			if args[0] == "add" {
+				err = db.AddMirrors(root, args[1], args[2:])
+			} else {
+				err = db.RemoveMirrors(root, args[1], args[2:])
+			}
+			if err != nil {
+				fatalf("remote mirror %v: %v\n", args[0], err)
+			}
+		case "auth":
+			if len(args) == 0 {
+				cs, err := db.ListCredentials(root)
+				if err != nil {
+					fatalf("list credentials: %v\n", err)
+				}
+				if err := list(output, cs, credentialRows(cs)); err != nil {
+					fatalf("list credentials: %v\n", err)
+				}
+				break
+			}
+			if len(args) < 2 {
+				fatalf("usage: pm remote auth [<uri> <basic <username>|token|cert <cert.pem> <key.pem>|none>]\n\nsecrets are read from stdin\n")
+			}
+			uri, kind := args[0], args[1]
+			if kind == "none" {
+				if err := db.RemoveCredential(root, uri); err != nil {
+					fatalf("remove credential: %v\n", err)
+				}
+				break
+			}
+			c := db.Credential{}
+			switch {
+			case kind == "basic" && len(args) == 3:
+				c.Username = args[2]
+				p, err := secret("password: ")
+				if err != nil {
+					fatalf("reading password: %v\n", err)
+				}
+				c.Password = p
+			case kind == "token" && len(args) == 2:
+				t, err := secret("token: ")
+				if err != nil {
+					fatalf("reading token: %v\n", err)
+				}
+				c.Token = t
+			case kind == "cert" && len(args) == 4:
+				var err error
+				if c.Cert, err = filepath.Abs(args[2]); err != nil {
+					fatalf("cert path: %v\n", err)
+				}
+				if c.Key, err = filepath.Abs(args[3]); err != nil {
+					fatalf("key path: %v\n", err)
+				}
+			default:
+				fatalf("usage: pm remote auth [<uri> <basic <username>|token|cert <cert.pem> <key.pem>|none>]\n")
+			}
+			if err := db.SetCredential(root, uri, c); err != nil {
+				fatalf("set credential: %v\n", err)
+			}
+		case "verify":
+			if len(args) != 1 {
+				fatalf("usage: pm remote verify <uri>\n")
+			}
+			if err := db.VerifyMirrors(root, args[0]); err != nil {
+				fatalf("verifying mirrors: %v\n", err)
+			}
+		case "policy":
+			if len(args) == 0 {
+				ps, err := db.ListPolicies(root)
+				if err != nil {
+					fatalf("list policies: %v\n", err)
+				}
+				if err := list(output, ps, policyRows(ps)); err != nil {
+					fatalf("list policies: %v\n", err)
+				}
+				break
+			}
+			if len(args) < 2 {
+				fatalf("missing arg\n\nusage: pm remote policy <uri> <threshold> [<fingerprints>]\n")
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				fatalf("parsing threshold: %v\n", err)
+			}
+			p, err := keyring.NewPolicy(n, args[2:])
+			if err != nil {
+				fatalf("policy: %v\n", err)
+			}
+			if err := db.SetPolicy(root, args[0], p); err != nil {
+				fatalf("set policy: %v\n", err)
+			}
 		default:
 			fatalf("unknown package subcommand: %q\n\nusage: %v", sub, remoteUsage)
 		}
+	case "repo":
+		if len(os.Args[1:]) < 2 {
+			fatalf("pm repo: insufficient args\n\nusage: %v", repoUsage)
+		}
+		sub := os.Args[2]
+		args := os.Args[3:]
+		switch sub {
+		case "index":
+			if len(args) != 1 {
+				fatalf("usage: pm repo index <dir>\n\navailable.json is signed if PM_PGP_ID is set\n")
+			}
+			key, err := indexSigner(root, signID)
+			if err != nil {
+				fatalf("find secret key: %v\n", err)
+			}
+			if err := writeIndex(root, args[0], key); err != nil {
+				fatalf("indexing: %v\n", err)
+			}
+		case "promote":
+			if len(args) != 3 {
+				fatalf("usage: pm repo promote <from> <to> <name[@version]>\n")
+			}
+			from, to, label := args[0], args[1], args[2]
+			if strings.HasPrefix(from, "http://") || strings.HasPrefix(from, "https://") {
+				if err := repo.PromoteRemote(from, to, label, os.Getenv("PM_UPLOAD_TOKEN")); err != nil {
+					fatalf("promoting: %v\n", err)
+				}
+				break
+			}
+			p, err := repo.LoadPolicy(to, keyring.Policy{Threshold: 1})
+			if err != nil {
+				fatalf("loading policy: %v\n", err)
+			}
+			if _, err := repo.Promote(root, from, to, label, p); err != nil {
+				fatalf("promoting: %v\n", err)
+			}
+			key, err := indexSigner(root, signID)
+			if err != nil {
+				fatalf("find secret key: %v\n", err)
+			}
+			for _, dir := range []string{from, to} {
+				if err := writeIndex(root, dir, key); err != nil {
+					fatalf("indexing %q: %v\n", dir, err)
+				}
+			}
+		case "prune":
+			dry := len(args) > 0 && args[0] == "-n"
+			if dry {
+				args = args[1:]
+			}
+			if len(args) != 1 {
+				fatalf("usage: pm repo prune [-n] <dir>\n")
+			}
+			dir := args[0]
+			r, ok, err := repo.LoadRetention(dir)
+			if err != nil {
+				fatalf("loading retention: %v\n", err)
+			}
+			if !ok {
+				fatalf("%q has no %v\n", dir, repo.RetentionFile)
+			}
+			ms, err := repo.Prune(dir, r, time.Now(), dry)
+			ps := prunedList(ms)
+			if err := list(output, ps, prunedRows(ps)); err != nil {
+				fatalf("listing pruned: %v\n", err)
+			}
+			if err != nil {
+				fatalf("pruning: %v\n", err)
+			}
+			if dry {
+				break
+			}
+			key, err := indexSigner(root, signID)
+			if err != nil {
+				fatalf("find secret key: %v\n", err)
+			}
+			if err := writeIndex(root, dir, key); err != nil {
+				fatalf("indexing: %v\n", err)
+			}
+		default:
+			fatalf("unknown repo subcommand: %q\n\nusage: %v", sub, repoUsage)
+		}
 	case "pull":
 		if err := db.Pull(root); err != nil {
 			fatalf("pulling available packages: %v\n", err)
 		}
 	case "available", "av":
-		if err := db.ListAvailable(root, os.Stdout); err != nil {
-			fatalf("pulling available packages: %v\n", err)
+		ps, err := db.ListAvailable(root)
+		if err != nil {
+			fatalf("listing available packages: %v\n", err)
 		}
-	case "install", "in":
-		if len(os.Args[1:]) < 2 {
-			fatalf("pm install: insufficient args\n\nusage: pm install [pkg1, pkg2, ..., pkgN]\n")
+		if err := list(output, ps, availableRows(ps)); err != nil {
+			fatalf("listing available packages: %v\n", err)
+		}
+	case "info":
+		if len(os.Args[2:]) != 1 {
+			fatalf("usage: pm info <pkg>[@version]\n")
+		}
+		i, err := db.Info(root, os.Args[2])
+		if err != nil {
+			fatalf("info: %v\n", err)
+		}
+		if err := list(output, i, infoRows(i)); err != nil {
+			fatalf("info: %v\n", err)
+		}
+	case "search":
+		terms := os.Args[2:]
+		all := len(terms) > 0 && terms[0] == "--all-versions"
+		if all {
+			terms = terms[1:]
+		}
+		if len(terms) < 1 {
+			fatalf("pm search: insufficient args\n\nusage: pm search [--all-versions] [term1, term2, ..., termN]\n")
+		}
+		rs, err := db.Search(root, terms, all)
+		if err != nil {
+			fatalf("searching available packages: %v\n", err)
 		}
+		if err := list(output, rs, searchRows(rs)); err != nil {
+			fatalf("searching available packages: %v\n", err)
+		}
+	case "install", "in":
 		pkgs := os.Args[2:]
+		only := len(pkgs) > 0 && pkgs[0] == "--download-only"
+		if only {
+			pkgs = pkgs[1:]
+		}
+		if len(pkgs) < 1 {
+			fatalf("pm install: insufficient args\n\nusage: pm install [--download-only] [pkg1, pkg2, ..., pkgN, file.pkg]\n")
+		}
+		if only {
+			if err := pkg.Download(root, pkgs); err != nil {
+				fatalf("downloading: %v\n", err)
+			}
+			break
+		}
 		if err := pkg.Install(root, pkgs); err != nil {
 			fatalf("installing: %v\n", err)
 		}
+	case "cache":
+		if len(os.Args[1:]) < 2 {
+			fatalf("pm cache: insufficient args\n\nusage: %v", cacheUsage)
+		}
+		sub, args := os.Args[2], os.Args[3:]
+		switch sub {
+		case "ls":
+			cs, err := pkg.ListCache(root)
+			if err != nil {
+				fatalf("listing cache: %v\n", err)
+			}
+			if err := list(output, cs, cacheRows(cs)); err != nil {
+				fatalf("listing cache: %v\n", err)
+			}
+		case "clean":
+			if err := pkg.CleanCache(root); err != nil {
+				fatalf("cleaning cache: %v\n", err)
+			}
+		case "prune":
+			if len(args) != 2 || args[0] != "--keep" {
+				fatalf("usage: pm cache prune --keep N\n")
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				fatalf("--keep must be a non-negative integer, got %q\n", args[1])
+			}
+			ms, err := pkg.PruneCache(root, n)
+			ps := prunedList(ms)
+			if err := list(output, ps, prunedRows(ps)); err != nil {
+				fatalf("listing pruned: %v\n", err)
+			}
+			if err != nil {
+				fatalf("pruning cache: %v\n", err)
+			}
+		default:
+			fatalf("unknown cache subcommand: %q\n\nusage: %v", sub, cacheUsage)
+		}
 	case "ls":
 		if len(os.Args[1:]) == 1 {
-			if err := db.ListInstalled(root, os.Stdout); err != nil {
+			ps, err := db.ListInstalled(root)
+			if err != nil {
+				fatalf("listing installed: %v\n", err)
+			}
+			if err := list(output, ps, installedRows(ps)); err != nil {
 				fatalf("listing installed: %v\n", err)
 			}
 		} else {
-			if err := db.ListInstalledFiles(root, os.Stdout, os.Args[2:]); err != nil {
+			files, err := db.ListInstalledFiles(root, os.Args[2:])
+			if err != nil {
+				fatalf("listing installed: %v\n", err)
+			}
+			if err := list(output, files, fileRows(files)); err != nil {
 				fatalf("listing installed: %v\n", err)
 			}
 		}
@@ -252,6 +719,43 @@ func main() {
 		if err := pkg.Remove(root, pkgs); err != nil {
 			fatalf("removing: %v\n", err)
 		}
+	case "pin":
+		args := os.Args[2:]
+		if len(args) != 3 || args[1] != "--remote" {
+			fatalf("usage: pm pin <pkg> --remote <name>\n")
+		}
+		if err := db.Pin(root, pm.Name(args[0]), args[2]); err != nil {
+			fatalf("pin: %v\n", err)
+		}
+	case "unpin":
+		if len(os.Args[2:]) != 1 {
+			fatalf("usage: pm unpin <pkg>\n")
+		}
+		if err := db.Unpin(root, pm.Name(os.Args[2])); err != nil {
+			fatalf("unpin: %v\n", err)
+		}
+	case "hold":
+		if len(os.Args[2:]) != 1 {
+			fatalf("usage: pm hold <pkg>[@version]\n")
+		}
+		if err := db.Hold(root, os.Args[2]); err != nil {
+			fatalf("hold: %v\n", err)
+		}
+	case "unhold":
+		if len(os.Args[2:]) != 1 {
+			fatalf("usage: pm unhold <pkg>\n")
+		}
+		if err := db.Unhold(root, pm.Name(os.Args[2])); err != nil {
+			fatalf("unhold: %v\n", err)
+		}
+	case "pins":
+		ps, err := db.ListPins(root)
+		if err != nil {
+			fatalf("listing pins: %v\n", err)
+		}
+		if err := list(output, ps, pinRows(ps)); err != nil {
+			fatalf("listing pins: %v\n", err)
+		}
 	case "version", "v":
 		fmt.Printf("pm: version %v\n", Version)
 	default:
@@ -259,11 +763,101 @@ func main() {
 	}
 }
 
+// secret reads a line from stdin, prompting for it without echo if stdin is
+// a terminal.
+func secret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		b, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	s := bufio.NewScanner(os.Stdin)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return "", err
+		}
+		return "", errors.New("no input")
+	}
+	return strings.TrimSpace(s.Text()), nil
+}
+
 func fatalf(f string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, f, args...)
 	os.Exit(1)
 }
 
+// signer finds the OpenPGP or ed25519 secret key for id, prompting for its
+// passphrase if necessary.
+func signer(root, id string) (keyring.Signer, error) {
+	return keyring.FindSigner(root, id, func() ([]byte, error) {
+		return keyring.Passphrase(fmt.Sprintf("passphrase for %v: ", id))
+	})
+}
+
+// httpConfig returns the http configuration, adjusted by the PM_HTTP_*
+// environment variables.
+func httpConfig() (db.HTTPConfig, error) {
+	c := db.DefaultHTTPConfig
+	for _, d := range []struct {
+		env string
+		v   *time.Duration
+	}{
+		{"PM_HTTP_CONNECT_TIMEOUT", &c.ConnectTimeout},
+		{"PM_HTTP_READ_TIMEOUT", &c.ReadTimeout},
+	} {
+		s := os.Getenv(d.env)
+		if s == "" {
+			continue
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return c, errors.Wrapf(err, "parsing %v", d.env)
+		}
+		*d.v = v
+	}
+	if s := os.Getenv("PM_HTTP_RETRIES"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("PM_HTTP_RETRIES must be a non-negative integer, got %q", s)
+		}
+		c.Retries = n
+	}
+	c.Proxy = os.Getenv("PM_HTTP_PROXY")
+	c.CAFile = os.Getenv("PM_CA_FILE")
+	return c, nil
+}
+
+// indexSigner returns the Signer used for available.json, which is nil if id
+// is empty.
+func indexSigner(root, id string) (keyring.Signer, error) {
+	if id == "" {
+		return nil, nil
+	}
+	return signer(root, id)
+}
+
+// writeIndex verifies the packages in dir against its policy and writes its
+// available.json, reporting any skipped packages on stderr.
+func writeIndex(root, dir string, key keyring.Signer) error {
+	p, err := repo.LoadPolicy(dir, keyring.Policy{Threshold: 1})
+	if err != nil {
+		return errors.Wrap(err, "loading policy")
+	}
+	a, skips, err := repo.VerifiedIndex(root, dir, p)
+	if err != nil {
+		return err
+	}
+	for _, s := range skips {
+		fmt.Fprintf(os.Stderr, "skipped %v\n", s)
+	}
+	return repo.WriteIndex(dir, a, key)
+}
+
 func mkdirs(root string) error {
 	d := filepath.Join(root, "var", "lib", "pm")
 	if !fs.Exists(d) {
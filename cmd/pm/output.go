@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"mcquay.me/pm"
+	"mcquay.me/pm/db"
+	"mcquay.me/pm/keyring"
+	"mcquay.me/pm/pkg"
+)
+
+// outputs are the formats listings can be printed in: text, aligned for
+// people; tsv, tab-separated; or json, as documented in README.md.
+var outputs = map[string]bool{"text": true, "tsv": true, "json": true}
+
+// list prints v to stdout as json, or else rows, one per line, in the given
+// output format.
+//
+// Rows are padded to the same number of fields, so that columns line up for
+// cut and awk; empty fields are printed as - in text.
+func list(output string, v interface{}, rows [][]string) error {
+	switch output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(v)
+	}
+	n := 0
+	for _, r := range rows {
+		if len(r) > n {
+			n = len(r)
+		}
+	}
+	for i, r := range rows {
+		for len(r) < n {
+			r = append(r, "")
+		}
+		if output == "text" {
+			for j := range r {
+				if r[j] == "" {
+					r[j] = "-"
+				}
+			}
+		}
+		rows[i] = r
+	}
+	switch output {
+	case "tsv":
+		for _, r := range rows {
+			fmt.Println(strings.Join(r, "\t"))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, r := range rows {
+		fmt.Fprintln(w, strings.Join(r, "\t"))
+	}
+	return w.Flush()
+}
+
+func keyRows(ks []keyring.Key) [][]string {
+	r := [][]string{}
+	for _, k := range ks {
+		exp := "never"
+		if k.Expires != nil {
+			exp = k.Expires.Format("2006-01-02")
+		}
+		ids := strings.Join(k.Identities, ",")
+		if k.Unprotected {
+			ids += " (unprotected)"
+		}
+		r = append(r, []string{
+			k.Keyring,
+			k.Fingerprint,
+			k.Algorithm,
+			k.Created.Format("2006-01-02"),
+			exp,
+			"[" + k.Capabilities + "]",
+			ids,
+		})
+	}
+	return r
+}
+
+func remoteRows(rs []db.RemoteEntry) [][]string {
+	r := [][]string{}
+	for _, e := range rs {
+		state := "enabled"
+		if !e.Enabled {
+			state = "disabled"
+		}
+		r = append(r, []string{e.Name, strconv.Itoa(e.Priority), state, e.URL, strings.Join(e.Mirrors, ",")})
+	}
+	return r
+}
+
+func credentialRows(cs []db.CredentialEntry) [][]string {
+	r := [][]string{}
+	for _, c := range cs {
+		r = append(r, []string{c.URL, c.Kind})
+	}
+	return r
+}
+
+func policyRows(ps []db.PolicyEntry) [][]string {
+	r := [][]string{}
+	for _, p := range ps {
+		r = append(r, []string{p.URL, strconv.Itoa(p.Threshold), strings.Join(p.Keys, ",")})
+	}
+	return r
+}
+
+func availableRows(ps []db.AvailablePackage) [][]string {
+	r := [][]string{}
+	for _, p := range ps {
+		r = append(r, []string{string(p.Name), string(p.Version), p.Remote})
+	}
+	return r
+}
+
+func searchRows(ss []db.SearchResult) [][]string {
+	r := [][]string{}
+	for _, s := range ss {
+		installed := ""
+		switch s.Installed {
+		case "":
+		case s.Version:
+			installed = "[installed]"
+		default:
+			installed = fmt.Sprintf("[installed %v]", s.Installed)
+		}
+		r = append(r, []string{string(s.Name), string(s.Version), s.Remote, s.Description, installed})
+	}
+	return r
+}
+
+func infoRows(i db.PackageInfo) [][]string {
+	r := [][]string{
+		{"name:", string(i.Name)},
+		{"version:", string(i.Version)},
+		{"description:", i.Description},
+	}
+	for _, a := range i.Available {
+		r = append(r, []string{"available:", string(a.Version), a.Remote})
+	}
+	if len(i.Deps) > 0 {
+		r = append(r, []string{"depends:", strings.Join(i.Deps, ", ")})
+	}
+	if len(i.RequiredBy) > 0 {
+		ns := []string{}
+		for _, n := range i.RequiredBy {
+			ns = append(ns, string(n))
+		}
+		r = append(r, []string{"required by:", strings.Join(ns, ", ")})
+	}
+	if i.Installed == nil {
+		return append(r, []string{"installed:", "no"})
+	}
+	in := i.Installed
+	r = append(r,
+		[]string{"installed:", string(in.Version), in.Date.Format("2006-01-02 15:04:05 MST")},
+		[]string{"size:", db.Bytesize(in.Size), fmt.Sprintf("%d files", in.Files)},
+	)
+	if len(in.Signers) == 0 {
+		r = append(r, []string{"signed by:", "unknown"})
+	}
+	for _, s := range in.Signers {
+		r = append(r, []string{"signed by:", s})
+	}
+	return r
+}
+
+func installedRows(ps []db.InstalledPackage) [][]string {
+	r := [][]string{}
+	for _, p := range ps {
+		r = append(r, []string{string(p.Name), string(p.Version), p.Source})
+	}
+	return r
+}
+
+func fileRows(files []db.InstalledFiles) [][]string {
+	r := [][]string{}
+	for _, f := range files {
+		for _, n := range f.Files {
+			r = append(r, []string{n})
+		}
+	}
+	return r
+}
+
+func cacheRows(cs []pkg.CachedPackage) [][]string {
+	r := [][]string{}
+	for _, c := range cs {
+		r = append(r, []string{string(c.Name), string(c.Version), c.Digest, strconv.FormatInt(c.Size, 10)})
+	}
+	return r
+}
+
+func pinRows(ps []db.PinEntry) [][]string {
+	r := [][]string{}
+	for _, p := range ps {
+		switch {
+		case p.Kind == "pin":
+			r = append(r, []string{string(p.Name), p.Kind, p.Remote})
+		case p.Version == "":
+			r = append(r, []string{string(p.Name), p.Kind, "*"})
+		default:
+			r = append(r, []string{string(p.Name), p.Kind, string(p.Version)})
+		}
+	}
+	return r
+}
+
+// pruned is a package removed by pm cache prune or pm repo prune.
+type pruned struct {
+	Name    pm.Name    `json:"name"`
+	Version pm.Version `json:"version"`
+}
+
+func prunedList(ms pm.Metas) []pruned {
+	r := []pruned{}
+	for _, m := range ms {
+		r = append(r, pruned{Name: m.Name, Version: m.Version})
+	}
+	return r
+}
+
+func prunedRows(ps []pruned) [][]string {
+	r := [][]string{}
+	for _, p := range ps {
+		r = append(r, []string{string(p.Name), string(p.Version)})
+	}
+	return r
+}
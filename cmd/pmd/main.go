@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"mcquay.me/fs"
+	"mcquay.me/pm/keyring"
+	"mcquay.me/pm/repo"
+)
+
+// Version stores the current version, and is updated at build time.
+const Version = "dev"
+
+const usage = `pmd: serve pm packages
+
+usage: pmd [flags]
+
+Each directory below -dir that contains .pkg files is served as a namespace,
+e.g. <dir>/linux/amd64/stable is served at /linux/amd64/stable.
+
+Uploads are enabled by setting PMD_UPLOAD_TOKEN; uploaded packages must be
+signed by a key in the $PM_ROOT keyring, optionally restricted to the
+fingerprints given with -publishers.
+
+If PM_PGP_ID is set, each namespace's available.json is signed by that key
+in the $PM_ROOT keyring and served as available.json.asc.
+
+With -prune, namespaces containing a retention.json are periodically pruned.
+
+flags:
+`
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	dir := flag.String("dir", ".", "repository directory")
+	publishers := flag.String("publishers", "", "comma-separated fingerprints of keys allowed to sign uploads")
+	prune := flag.Duration("prune", 0, "how often to prune namespaces; 0 disables pruning")
+	version := flag.Bool("version", false, "print version information")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("pmd: version %v\n", Version)
+		return
+	}
+
+	root := os.Getenv("PM_ROOT")
+	if root == "" {
+		root = "/usr/local"
+	}
+
+	if !fs.IsDir(*dir) {
+		fatalf("%q is not a directory\n", *dir)
+	}
+
+	s := repo.NewServer(*dir, root)
+	s.Token = os.Getenv("PMD_UPLOAD_TOKEN")
+	if *publishers != "" {
+		p, err := keyring.NewPolicy(1, strings.Split(*publishers, ","))
+		if err != nil {
+			fatalf("publishers: %v\n", err)
+		}
+		s.Publishers = p
+	}
+
+	if id := os.Getenv("PM_PGP_ID"); id != "" {
+		key, err := keyring.FindSigner(root, id, func() ([]byte, error) {
+			return keyring.Passphrase(fmt.Sprintf("passphrase for %v: ", id))
+		})
+		if err != nil {
+			fatalf("finding signing key: %v\n", err)
+		}
+		s.Key = key
+	}
+
+	if *prune > 0 {
+		go pruner(*dir, *prune)
+	}
+
+	log.Printf("serving %q on %v", *dir, *addr)
+	if err := http.ListenAndServe(*addr, s); err != nil {
+		fatalf("serving: %v\n", err)
+	}
+}
+
+// pruner prunes the namespaces below dir every d.
+func pruner(dir string, d time.Duration) {
+	for now := range time.Tick(d) {
+		pruned, err := repo.PruneTree(dir, now)
+		for ns, ms := range pruned {
+			for _, m := range ms {
+				log.Printf("pruned %v@%v from %q", m.Name, m.Version, ns)
+			}
+		}
+		if err != nil {
+			log.Printf("pruning: %v", err)
+		}
+	}
+}
+
+func fatalf(f string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, f, args...)
+	os.Exit(1)
+}
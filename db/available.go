@@ -2,9 +2,6 @@ package db
 
 import (
 	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 
@@ -13,35 +10,115 @@ import (
 	"mcquay.me/pm"
 )
 
-const an = "var/lib/pm/available.json"
+const (
+	an  = "var/lib/pm/available.json"
+	pln = "var/lib/pm/pulled.json"
+)
+
+// pulled records the last index fetched from a remote, which of its urls it
+// came from, and its Validators, so that unchanged remotes need not be
+// transferred again.
+type pulled struct {
+	From       string       `json:"from,omitempty"`
+	Validators Validators   `json:"validators"`
+	Available  pm.Available `json:"available"`
+}
 
-// Pull updates the available package database.
+// Pull updates the available package database from the enabled remotes.
+//
+// Each remote's index is only transferred if it has changed since the last
+// Pull. If a remote cannot be reached its mirrors are tried in turn. The
+// indexes of a remote with mirrors must be signed, whichever url serves them.
+// The placeholders in remote urls are expanded for the host, per Expand.
 func Pull(root string) error {
-	db, err := load(root)
+	all, err := load(root)
 	if err != nil {
 		return errors.Wrap(err, "loading db")
 	}
+	db := all.enabled()
+	prev, err := loadPulled(root)
+	if err != nil {
+		return errors.Wrap(err, "loading pulled indexes")
+	}
+	if err := Authenticate(root); err != nil {
+		return errors.Wrap(err, "authenticating")
+	}
 
-	o := pm.Available{}
+	ps := make([]pulled, len(db))
+	err = Parallel(len(db), func(i int) error {
+		r := db[i]
+		p := prev[r.String()]
+		us := r.URLs()
+		errs := Errors{}
+		for _, u := range us {
+			u = Expand(u)
+			v := Validators{}
+			if p.From == u.String() {
+				v = p.Validators
+			}
+			np, err := pullIndex(root, r, u, v)
+			if err != nil {
+				if len(us) > 1 {
+					err = errors.Wrapf(err, "%v", u.String())
+				}
+				errs = append(errs, err)
+				continue
+			}
+			ps[i] = p
+			if np != nil {
+				ps[i] = *np
+			}
+			return nil
+		}
+		var err error = errs
+		if len(errs) == 1 {
+			err = errs[0]
+		}
+		return errors.Wrapf(err, "fetching available for %q", r.String())
+	})
+	if err != nil {
+		return err
+	}
+
+	cur := map[string]pulled{}
+	for i, r := range db {
+		cur[r.String()] = ps[i]
+	}
+	if err := savePulled(root, cur); err != nil {
+		return errors.Wrap(err, "saving pulled indexes")
+	}
+	return merge(root)
+}
+
+// merge writes the available package database from the indexes last pulled
+// from the enabled remotes, honoring pins.
+func merge(root string) error {
+	all, err := load(root)
+	if err != nil {
+		return errors.Wrap(err, "loading db")
+	}
+	db := all.enabled()
+	ps, err := loadPulled(root)
+	if err != nil {
+		return errors.Wrap(err, "loading pulled indexes")
+	}
+	pins, err := LoadPins(root)
+	if err != nil {
+		return errors.Wrap(err, "loading pins")
+	}
 
 	// Order here is important: the guarantee made is that any packages that
-	// exist in multiple remotes will be fetched by the first configured
-	// remote, which is why we traverse the database in reverse.
-	//
-	// TODO (sm): make this concurrent
+	// exist in multiple remotes will be fetched by the remote with the
+	// smallest priority value, which is why we merge the results in reverse.
+	o := pm.Available{}
 	for i := range db {
-		u := db[len(db)-i-1]
-		resp, err := http.Get(u.String() + "/available.json")
-		if err != nil {
-			return errors.Wrap(err, "http get")
-		}
-
-		a := pm.Available{}
-		if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
-			return errors.Wrapf(err, "decode remote available for %q", u.String())
+		o.Update(ps[db[len(db)-i-1].String()].Available)
+	}
+	for n, u := range pins.Remotes {
+		delete(o, n)
+		for _, m := range ps[u].Available[n] {
+			o.Add(m)
 		}
-		a.SetRemote(u)
-		o.Update(a)
 	}
 	if err := saveAvailable(root, o); err != nil {
 		return errors.Wrap(err, "saving available db")
@@ -49,16 +126,80 @@ func Pull(root string) error {
 	return nil
 }
 
-// ListAvailable prints all installable packages
-func ListAvailable(root string, w io.Writer) error {
+// AvailablePackage is an installable package, as listed by ListAvailable.
+type AvailablePackage struct {
+	Name    pm.Name    `json:"name"`
+	Version pm.Version `json:"version"`
+
+	// Remote is the name of the remote offering the package, or its url if
+	// it is no longer configured.
+	Remote string `json:"remote"`
+}
+
+// ListAvailable returns all installable packages, and the names of their
+// remotes.
+func ListAvailable(root string) ([]AvailablePackage, error) {
 	db, err := LoadAvailable(root)
 	if err != nil {
-		return errors.Wrap(err, "loading")
+		return nil, errors.Wrap(err, "loading")
+	}
+	names, err := RemoteNames(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading remotes")
 	}
+	r := []AvailablePackage{}
 	for m := range db.Traverse() {
-		fmt.Fprintf(w, "%v\t%v\t%v\n", m.Name, m.Version, m.Remote.String())
+		r = append(r, available(m, names))
 	}
-	return nil
+	return r, nil
+}
+
+// available describes m, naming its remote from names.
+func available(m pm.Meta, names map[string]string) AvailablePackage {
+	remote, ok := names[m.Remote.String()]
+	if !ok {
+		remote = m.Remote.String()
+	}
+	return AvailablePackage{Name: m.Name, Version: m.Version, Remote: remote}
+}
+
+// SearchResult is a package found by Search.
+type SearchResult struct {
+	AvailablePackage
+	Description string `json:"description"`
+
+	// Installed is the installed version of the package, if any.
+	Installed pm.Version `json:"installed,omitempty"`
+}
+
+// Search returns the available packages matching terms (see
+// pm.Available.Search), and their installed versions.
+func Search(root string, terms []string, all bool) ([]SearchResult, error) {
+	db, err := LoadAvailable(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading")
+	}
+	ms, err := db.Search(terms, all)
+	if err != nil {
+		return nil, errors.Wrap(err, "searching")
+	}
+	names, err := RemoteNames(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading remotes")
+	}
+	in, err := loadi(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading installed db")
+	}
+	r := []SearchResult{}
+	for _, m := range ms {
+		r = append(r, SearchResult{
+			AvailablePackage: available(m, names),
+			Description:      m.Description,
+			Installed:        in[m.Name].Version,
+		})
+	}
+	return r, nil
 }
 
 // LoadAvailable returns the collection of available packages
@@ -97,3 +238,40 @@ func saveAvailable(root string, db pm.Available) error {
 	}
 	return nil
 }
+
+func loadPulled(root string) (map[string]pulled, error) {
+	r := map[string]pulled{}
+	dbn := filepath.Join(root, pln)
+
+	if !fs.Exists(dbn) {
+		return r, nil
+	}
+
+	f, err := os.Open(dbn)
+	if err != nil {
+		return r, errors.Wrap(err, "open")
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return r, errors.Wrap(err, "decoding db")
+	}
+
+	return r, nil
+}
+
+func savePulled(root string, db map[string]pulled) error {
+	f, err := os.Create(filepath.Join(root, pln))
+	if err != nil {
+		return errors.Wrap(err, "create")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(&db); err != nil {
+		return errors.Wrap(err, "encoding db")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close db")
+	}
+	return nil
+}
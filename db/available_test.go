@@ -0,0 +1,54 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"mcquay.me/pm"
+)
+
+func TestSearch(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	srv := filepath.Join(root, "srv", "stable")
+	if err := os.MkdirAll(srv, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	av := `{
+		"foo": {"1.0.0": {"name": "foo", "version": "1.0.0", "description": "frobnicator"}},
+		"bar": {
+			"1.0.0": {"name": "bar", "version": "1.0.0", "description": "uses foo"},
+			"1.1.0": {"name": "bar", "version": "1.1.0", "description": "uses foo"}
+		}
+	}`
+	if err := ioutil.WriteFile(filepath.Join(srv, "available.json"), []byte(av), 0644); err != nil {
+		t.Fatalf("writing available: %v", err)
+	}
+	if err := AddRemotes(root, []string{srv}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	for _, m := range []pm.Meta{{Name: "foo", Version: "1.0.0"}, {Name: "bar", Version: "1.0.0"}} {
+		if err := AddInstalled(root, m); err != nil {
+			t.Fatalf("add installed: %v", err)
+		}
+	}
+
+	rs, err := Search(root, []string{"foo"}, false)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	want := []SearchResult{
+		{AvailablePackage{"foo", "1.0.0", "stable"}, "frobnicator", "1.0.0"},
+		{AvailablePackage{"bar", "1.1.0", "stable"}, "uses foo", "1.0.0"},
+	}
+	if !reflect.DeepEqual(rs, want) {
+		t.Fatalf("search: got %v, want %v", rs, want)
+	}
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+)
+
+const cn = "var/lib/pm/credentials.json"
+
+// Credential authenticates requests to a remote with one of: HTTP basic
+// auth, a bearer token, or a TLS client certificate.
+type Credential struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	Token string `json:"token,omitempty"`
+
+	// Cert and Key name PEM files holding a client certificate and its
+	// private key.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+// Kind describes how c authenticates.
+func (c Credential) Kind() string {
+	switch {
+	case c.Username != "":
+		return "basic"
+	case c.Token != "":
+		return "token"
+	case c.Cert != "":
+		return "cert"
+	}
+	return "none"
+}
+
+// Valid validates the contents of a Credential.
+func (c Credential) Valid() (bool, error) {
+	n := 0
+	if c.Username != "" {
+		n++
+	}
+	if c.Token != "" {
+		n++
+	}
+	if c.Cert != "" || c.Key != "" {
+		if c.Cert == "" || c.Key == "" {
+			return false, errors.New("cert and key must be given together")
+		}
+		n++
+	}
+	if n != 1 {
+		return false, errors.New("exactly one of username, token, or cert must be set")
+	}
+	if c.Password != "" && c.Username == "" {
+		return false, errors.New("password requires a username")
+	}
+	return true, nil
+}
+
+// Credentials maps the url of a remote, or of one of its mirrors, to the
+// Credential used to access it.
+type Credentials map[string]Credential
+
+// SetCredential stores the credential used to fetch from uri, which must be a
+// configured remote or mirror.
+func SetCredential(root, uri string, c Credential) error {
+	if _, err := c.Valid(); err != nil {
+		return errors.Wrap(err, "invalid credential")
+	}
+	u, err := configured(root, uri)
+	if err != nil {
+		return err
+	}
+	cs, err := loadc(root)
+	if err != nil {
+		return errors.Wrap(err, "loading credentials")
+	}
+	cs[u] = c
+	return savec(root, cs)
+}
+
+// RemoveCredential removes the credential used to fetch from uri.
+func RemoveCredential(root, uri string) error {
+	u, err := parseRemote(uri)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %q", uri)
+	}
+	cs, err := loadc(root)
+	if err != nil {
+		return errors.Wrap(err, "loading credentials")
+	}
+	if _, ok := cs[u.String()]; !ok {
+		return fmt.Errorf("no credential for %q", u.String())
+	}
+	delete(cs, u.String())
+	return savec(root, cs)
+}
+
+// CredentialEntry names a url that has a credential, as listed by
+// ListCredentials.
+type CredentialEntry struct {
+	URL string `json:"url"`
+
+	// Kind is one of basic, token, or cert; see Credential.Kind.
+	Kind string `json:"kind"`
+}
+
+// ListCredentials returns the urls that have credentials, and their kinds.
+// Secrets are never returned.
+func ListCredentials(root string) ([]CredentialEntry, error) {
+	cs, err := loadc(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading credentials")
+	}
+	us := []string{}
+	for u := range cs {
+		us = append(us, u)
+	}
+	sort.Strings(us)
+	r := []CredentialEntry{}
+	for _, u := range us {
+		r = append(r, CredentialEntry{URL: u, Kind: cs[u].Kind()})
+	}
+	return r, nil
+}
+
+// auth is a Credential in use, with the client that presents its
+// certificate if it has one, or else nil.
+type auth struct {
+	prefix string
+	cred   Credential
+	client *http.Client
+}
+
+var (
+	authMu sync.Mutex
+	auths  []auth
+)
+
+// Authenticate loads the credentials stored below root for use by all
+// subsequent fetches.
+func Authenticate(root string) error {
+	cs, err := loadc(root)
+	if err != nil {
+		return errors.Wrap(err, "loading credentials")
+	}
+	as := []auth{}
+	for u, c := range cs {
+		pu, err := url.Parse(u)
+		if err != nil {
+			return errors.Wrapf(err, "parsing %q", u)
+		}
+		// credentials are stored under the configured url, but fetches are
+		// made from its expansion.
+		eu := Expand(*pu)
+		a := auth{prefix: eu.String(), cred: c}
+		if c.Cert != "" {
+			cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+			if err != nil {
+				return errors.Wrapf(err, "loading client certificate for %q", u)
+			}
+			if a.client, err = newClient(httpConfig, cert); err != nil {
+				return errors.Wrap(err, "creating client")
+			}
+		}
+		as = append(as, a)
+	}
+	authMu.Lock()
+	auths = as
+	authMu.Unlock()
+	return nil
+}
+
+// authorize returns the client to use to fetch uri, adding any credential
+// configured for it to req.
+func authorize(req *http.Request, uri string) *http.Client {
+	authMu.Lock()
+	defer authMu.Unlock()
+	best := -1
+	for i, a := range auths {
+		if uri != a.prefix && !strings.HasPrefix(uri, a.prefix+"/") {
+			continue
+		}
+		if best < 0 || len(a.prefix) > len(auths[best].prefix) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return client
+	}
+	a := auths[best]
+	switch {
+	case a.cred.Username != "":
+		req.SetBasicAuth(a.cred.Username, a.cred.Password)
+	case a.cred.Token != "":
+		req.Header.Set("Authorization", "Bearer "+a.cred.Token)
+	}
+	if a.client == nil {
+		return client
+	}
+	return a.client
+}
+
+// configured returns the normalized form of uri if it is the url of a
+// configured remote or mirror.
+func configured(root, uri string) (string, error) {
+	u, err := parseRemote(uri)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing %q", uri)
+	}
+	db, err := load(root)
+	if err != nil {
+		return "", errors.Wrap(err, "loading remotes")
+	}
+	for _, r := range db {
+		for _, ru := range r.URLs() {
+			if ru.String() == u.String() {
+				return u.String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%q is not a configured remote or mirror", u.String())
+}
+
+func loadc(root string) (Credentials, error) {
+	r := Credentials{}
+	dbn := filepath.Join(root, cn)
+
+	if !fs.Exists(dbn) {
+		return r, nil
+	}
+
+	f, err := os.Open(dbn)
+	if err != nil {
+		return r, errors.Wrap(err, "open")
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return r, errors.Wrap(err, "stat")
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return r, fmt.Errorf("%q must not be accessible by other users (mode %v)", dbn, fi.Mode().Perm())
+	}
+
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return r, errors.Wrap(err, "decoding db")
+	}
+
+	return r, nil
+}
+
+func savec(root string, db Credentials) error {
+	fn := filepath.Join(root, cn)
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "create")
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return errors.Wrap(err, "chmod")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(&db); err != nil {
+		f.Close()
+		return errors.Wrap(err, "encoding db")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close db")
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const authAvailable = `{"foo": {"1.0.0": {"name": "foo", "version": "1.0.0", "description": "private"}}}`
+
+func TestCredentials(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok := false
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/basic/"):
+			u, p, _ := r.BasicAuth()
+			ok = u == "alice" && p == "s3cret"
+		case strings.HasPrefix(r.URL.Path, "/token/"):
+			ok = r.Header.Get("Authorization") == "Bearer t0ken"
+		}
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(authAvailable))
+	}))
+	defer ts.Close()
+
+	basic, token := ts.URL+"/basic", ts.URL+"/token"
+	if err := SetCredential(root, basic, Credential{Username: "alice", Password: "s3cret"}); err == nil {
+		t.Fatalf("set credential for unconfigured remote")
+	}
+	if err := AddRemotes(root, []string{basic, token}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := Pull(root); err == nil {
+		t.Fatalf("pulled private remotes without credentials")
+	}
+
+	if err := SetCredential(root, basic, Credential{Username: "alice", Password: "s3cret", Token: "t0ken"}); err == nil {
+		t.Fatalf("set ambiguous credential")
+	}
+	if err := SetCredential(root, basic, Credential{Username: "alice", Password: "s3cret"}); err != nil {
+		t.Fatalf("set basic: %v", err)
+	}
+	if err := SetCredential(root, token, Credential{Token: "t0ken"}); err != nil {
+		t.Fatalf("set token: %v", err)
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(root, cn))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0600); got != want {
+		t.Fatalf("mode: got %v, want %v", got, want)
+	}
+
+	for _, f := range []func() (interface{}, error){
+		func() (interface{}, error) { return ListRemotes(root) },
+		func() (interface{}, error) { return ListCredentials(root) },
+	} {
+		v, err := f()
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if bytes.Contains(b, []byte("s3cret")) || bytes.Contains(b, []byte("t0ken")) {
+			t.Fatalf("secret listed:\n%s", b)
+		}
+	}
+
+	if err := os.Chmod(filepath.Join(root, cn), 0644); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := Pull(root); err == nil {
+		t.Fatalf("used world readable credentials")
+	}
+	if err := os.Chmod(filepath.Join(root, cn), 0600); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+
+	if err := RemoveRemotes(root, []string{token}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	cs, err := loadc(root)
+	if err != nil {
+		t.Fatalf("load credentials: %v", err)
+	}
+	if _, ok := cs[token]; ok {
+		t.Fatalf("credential outlived its remote")
+	}
+	if err := RemoveCredential(root, basic); err != nil {
+		t.Fatalf("remove credential: %v", err)
+	}
+	if err := Pull(root); err == nil {
+		t.Fatalf("pulled after removing credential")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(authAvailable))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	ca := filepath.Join(root, "ca.pem")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644); err != nil {
+		t.Fatalf("writing ca: %v", err)
+	}
+	defer ConfigureHTTP(DefaultHTTPConfig)
+	c := DefaultHTTPConfig
+	c.CAFile, c.Retries = ca, 0
+	if err := ConfigureHTTP(c); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+
+	if err := AddRemotes(root, []string{ts.URL}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := Pull(root); err == nil {
+		t.Fatalf("pulled without client certificate")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	cert, kf := filepath.Join(root, "client.pem"), filepath.Join(root, "client.key")
+	if err := ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("writing cert: %v", err)
+	}
+	if err := ioutil.WriteFile(kf, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+
+	if err := SetCredential(root, ts.URL, Credential{Cert: cert, Key: kf}); err != nil {
+		t.Fatalf("set cert: %v", err)
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+}
+
+func TestTemplatedCredentials(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+	defer func(o, a string) { OS, Arch = o, a }(OS, Arch)
+	OS, Arch = "linux", "arm64"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/linux/arm64/stable/available.json" || r.Header.Get("Authorization") != "Bearer t0ken" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(authAvailable))
+	}))
+	defer ts.Close()
+
+	tmpl := ts.URL + "/{os}/{arch}/stable"
+	if err := AddRemotes(root, []string{tmpl}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := SetCredential(root, tmpl, Credential{Token: "t0ken"}); err != nil {
+		t.Fatalf("set token: %v", err)
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FetchFrom is like Fetch, but skips the first offset bytes of uri if
+// possible. Those bytes are only skipped if v shows that uri is unchanged
+// since they were fetched; without a usable validator in v all of uri is
+// fetched again. It also returns the offset the reader
+// actually starts at, which is 0 if the remote cannot resume, the total size
+// of uri, or -1 if it is unknown, and the validators of its current
+// contents.
+func FetchFrom(uri string, offset int64, v Validators) (io.ReadCloser, int64, int64, Validators, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, 0, -1, v, errors.Wrap(err, "url parse")
+	}
+	switch u.Scheme {
+	case "file", "":
+		f, err := os.Open(filepath.FromSlash(u.Path))
+		if err != nil {
+			return nil, 0, -1, v, errors.Wrap(err, "open")
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, -1, v, errors.Wrap(err, "stat")
+		}
+		nv := Validators{
+			ETag: fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+		}
+		if offset > fi.Size() || v.ETag != nv.ETag {
+			offset = 0
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, 0, -1, v, errors.Wrap(err, "seek")
+		}
+		return f, offset, fi.Size(), nv, nil
+	case "http", "https":
+		h := http.Header{}
+		// weak etags cannot be used with If-Range.
+		ir := ""
+		switch {
+		case v.ETag != "" && !strings.HasPrefix(v.ETag, "W/"):
+			ir = v.ETag
+		case v.LastModified != "":
+			ir = v.LastModified
+		}
+		if ir == "" {
+			// a bare Range could splice a changed uri onto the old bytes.
+			offset = 0
+		}
+		if offset > 0 {
+			h.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+			h.Set("If-Range", ir)
+		}
+		resp, err := get(uri, h)
+		if err != nil {
+			return nil, 0, -1, v, err
+		}
+		nv := Validators{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			size := int64(-1)
+			cr := resp.Header.Get("Content-Range")
+			if i := strings.LastIndex(cr, "/"); i >= 0 {
+				if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+					size = n
+				}
+			}
+			return resp.Body, offset, size, nv, nil
+		case http.StatusRequestedRangeNotSatisfiable:
+			// what we have is bigger than uri; start over.
+			resp.Body.Close()
+			return FetchFrom(uri, 0, v)
+		}
+		return resp.Body, 0, resp.ContentLength, nv, nil
+	}
+	return nil, 0, -1, v, errors.Errorf("unsupported url scheme %q", u.Scheme)
+}
+
+// Download fetches uri to the file fn.
+//
+// The contents are written to fn.part, which is renamed to fn once
+// complete. If fn.part already exists, as left by an interrupted Download,
+// only the remainder of uri is fetched if the remote supports it and can
+// show, by a strong ETag or Last-Modified, that uri has not changed since
+// fn.part was started; otherwise fn.part is discarded and uri fetched in
+// full. Transfers that fail part way are likewise resumed, and retried
+// according to the configured HTTPConfig.
+//
+// If p is not nil the transfer is reported to it.
+func Download(uri, fn string, p *Progress) error {
+	part := fn + ".part"
+	name := filepath.Base(fn)
+	wait := httpConfig.Backoff
+	for i := 0; ; i++ {
+		retry, err := fetchPart(uri, part, name, p)
+		if err == nil {
+			break
+		}
+		if !retry || i >= httpConfig.Retries {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+	p.finish(name)
+	if err := os.Rename(part, fn); err != nil {
+		return errors.Wrap(err, "rename")
+	}
+	if err := os.Remove(part + ".validators"); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing validators")
+	}
+	return nil
+}
+
+// fetchPart appends the remainder of uri to the file part, and reports
+// whether a failure happened part way through the transfer.
+//
+// The validators of uri are kept in part.validators, so that part is only
+// resumed if uri has not changed since part was started.
+func fetchPart(uri, part, name string, p *Progress) (bool, error) {
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return false, errors.Wrap(err, "open")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, errors.Wrap(err, "stat")
+	}
+	vn := part + ".validators"
+	v := Validators{}
+	if b, err := ioutil.ReadFile(vn); err == nil {
+		if err := json.Unmarshal(b, &v); err != nil {
+			return false, errors.Wrapf(err, "decoding %v", vn)
+		}
+	} else if !os.IsNotExist(err) {
+		return false, errors.Wrapf(err, "reading %v", vn)
+	}
+
+	rc, start, size, nv, err := FetchFrom(uri, fi.Size(), v)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	if start == 0 {
+		// the remote is sending all of uri, so what we have is discarded.
+		b, err := json.Marshal(nv)
+		if err != nil {
+			return false, errors.Wrap(err, "encoding validators")
+		}
+		if err := ioutil.WriteFile(vn, b, 0644); err != nil {
+			return false, errors.Wrapf(err, "writing %v", vn)
+		}
+	}
+	if err := f.Truncate(start); err != nil {
+		return false, errors.Wrap(err, "truncate")
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return false, errors.Wrap(err, "seek")
+	}
+
+	var w io.Writer = f
+	if p != nil {
+		w = io.MultiWriter(f, p.track(name, start, size))
+	}
+	if n, err := io.Copy(w, rc); err != nil {
+		return true, errors.Wrapf(err, "copy %q to disk after %d bytes", uri, start+n)
+	}
+	if err := f.Close(); err != nil {
+		return false, errors.Wrapf(err, "closing %v", part)
+	}
+	return false, nil
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mcquay.me/fs"
+)
+
+func TestDownloadResume(t *testing.T) {
+	defer quickRetries(t, time.Second)()
+	dir, del := dirMe(t)
+	defer del()
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	mu := sync.Mutex{}
+	ranges := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		n := len(ranges)
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Path == "/flaky" && n == 1 {
+			// send half, then drop the connection.
+			w.Header().Set("Content-Length", "10000")
+			w.Write(content[:5000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "foo.pkg", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		part   []byte
+		etag   string
+		ranges []string
+	}{
+		{path: "/flaky", ranges: []string{"", "bytes=5000-"}},
+		{path: "/partial", part: content[:3000], etag: `"v1"`, ranges: []string{"bytes=3000-"}},
+		{path: "/oversized", part: append(content, 'x'), etag: `"v1"`, ranges: []string{"bytes=10001-", ""}},
+		{path: "/unvalidated", part: content[:3000], ranges: []string{""}},
+	}
+	for _, test := range tests {
+		mu.Lock()
+		ranges = []string{}
+		mu.Unlock()
+		fn := filepath.Join(dir, strings.TrimPrefix(test.path, "/")+".pkg")
+		if test.part != nil {
+			if err := ioutil.WriteFile(fn+".part", test.part, 0644); err != nil {
+				t.Fatalf("writing part: %v", err)
+			}
+		}
+		if test.etag != "" {
+			v := []byte(fmt.Sprintf(`{"etag": %q}`, test.etag))
+			if err := ioutil.WriteFile(fn+".part.validators", v, 0644); err != nil {
+				t.Fatalf("writing validators: %v", err)
+			}
+		}
+		out := &bytes.Buffer{}
+		p := NewProgress(out, time.Millisecond)
+		if err := Download(ts.URL+test.path, fn, p); err != nil {
+			t.Fatalf("%v: download: %v", test.path, err)
+		}
+		p.Close()
+
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("%v: read: %v", test.path, err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Fatalf("%v: got %d bytes, want %d", test.path, len(b), len(content))
+		}
+		mu.Lock()
+		got := strings.Join(ranges, ",")
+		mu.Unlock()
+		if want := strings.Join(test.ranges, ","); got != want {
+			t.Fatalf("%v: ranges: got %q, want %q", test.path, got, want)
+		}
+		if !strings.Contains(out.String(), "total") {
+			t.Fatalf("%v: progress missing total: %q", test.path, out.String())
+		}
+	}
+}
+
+func TestDownloadChanged(t *testing.T) {
+	defer quickRetries(t, time.Second)()
+	dir, del := dirMe(t)
+	defer del()
+
+	old := bytes.Repeat([]byte("abcdefghij"), 1000)
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	mu := sync.Mutex{}
+	ifRanges := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		n := len(ifRanges)
+		mu.Unlock()
+		w.Header().Set("ETag", `"v2"`)
+		if r.URL.Path == "/flaky" && n == 1 {
+			w.Header().Set("Content-Length", "10000")
+			w.Write(content[:5000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "foo.pkg", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path     string
+		part     []byte
+		etag     string
+		ifRanges []string
+	}{
+		{path: "/changed", part: old[:3000], etag: `"v1"`, ifRanges: []string{`"v1"`}},
+		{path: "/unchanged", part: content[:3000], etag: `"v2"`, ifRanges: []string{`"v2"`}},
+		{path: "/flaky", ifRanges: []string{"", `"v2"`}},
+	}
+	for _, test := range tests {
+		mu.Lock()
+		ifRanges = []string{}
+		mu.Unlock()
+		fn := filepath.Join(dir, strings.TrimPrefix(test.path, "/")+".pkg")
+		if test.part != nil {
+			if err := ioutil.WriteFile(fn+".part", test.part, 0644); err != nil {
+				t.Fatalf("writing part: %v", err)
+			}
+			v := []byte(fmt.Sprintf(`{"etag": %q}`, test.etag))
+			if err := ioutil.WriteFile(fn+".part.validators", v, 0644); err != nil {
+				t.Fatalf("writing validators: %v", err)
+			}
+		}
+		if err := Download(ts.URL+test.path, fn, nil); err != nil {
+			t.Fatalf("%v: download: %v", test.path, err)
+		}
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("%v: read: %v", test.path, err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Fatalf("%v: got stale or corrupt content", test.path)
+		}
+		mu.Lock()
+		got := strings.Join(ifRanges, ",")
+		mu.Unlock()
+		if want := strings.Join(test.ifRanges, ","); got != want {
+			t.Fatalf("%v: If-Range: got %q, want %q", test.path, got, want)
+		}
+		if fs.Exists(fn + ".part.validators") {
+			t.Fatalf("%v: validators outlived the download", test.path)
+		}
+	}
+}
+
+func TestBytesize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{n: 0, want: "0 B"},
+		{n: 1023, want: "1023 B"},
+		{n: 1536, want: "1.5 KiB"},
+		{n: 5 << 30, want: "5.0 GiB"},
+	}
+	for _, test := range tests {
+		if got := Bytesize(test.n); got != test.want {
+			t.Fatalf("Bytesize(%d): got %q, want %q", test.n, got, test.want)
+		}
+	}
+}
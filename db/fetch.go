@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPConfig configures how remotes are fetched over http(s).
+type HTTPConfig struct {
+	// ConnectTimeout bounds establishing a connection, including the TLS
+	// handshake.
+	ConnectTimeout time.Duration
+
+	// ReadTimeout bounds how long a response may stall, whether waiting for
+	// its headers or while reading its body.
+	ReadTimeout time.Duration
+
+	// Retries is how many times a transient failure is retried.
+	Retries int
+
+	// Backoff is the delay before the first retry; it doubles for each
+	// subsequent retry.
+	Backoff time.Duration
+
+	// Proxy, if not empty, is the url of the proxy used for all requests.
+	// Otherwise the usual HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	// variables are honored.
+	Proxy string
+
+	// CAFile, if not empty, names a PEM bundle of certificate authorities to
+	// trust in addition to the system's.
+	CAFile string
+}
+
+// DefaultHTTPConfig is used until ConfigureHTTP is called.
+var DefaultHTTPConfig = HTTPConfig{
+	ConnectTimeout: 10 * time.Second,
+	ReadTimeout:    30 * time.Second,
+	Retries:        3,
+	Backoff:        500 * time.Millisecond,
+}
+
+var (
+	httpConfig = DefaultHTTPConfig
+	client, _  = newClient(DefaultHTTPConfig)
+)
+
+// ConfigureHTTP sets the configuration used for all subsequent http(s)
+// requests. It is not safe to call concurrently with fetches.
+func ConfigureHTTP(c HTTPConfig) error {
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+	httpConfig, client = c, cl
+	return nil
+}
+
+// Client returns the configured http client. Unlike Fetch, requests made
+// with it are not retried.
+func Client() *http.Client {
+	return client
+}
+
+// newClient returns a client configured by c, that presents certs to servers
+// that ask for a client certificate.
+func newClient(c HTTPConfig, certs ...tls.Certificate) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if c.Proxy != "" {
+		pu, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing proxy url")
+		}
+		proxy = http.ProxyURL(pu)
+	}
+
+	var tc *tls.Config
+	if len(certs) > 0 {
+		tc = &tls.Config{Certificates: certs}
+	}
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading ca bundle")
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificates found in %q", c.CAFile)
+		}
+		if tc == nil {
+			tc = &tls.Config{}
+		}
+		tc.RootCAs = pool
+	}
+
+	d := &net.Dialer{Timeout: c.ConnectTimeout, KeepAlive: 30 * time.Second}
+	t := &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := d.DialContext(ctx, network, addr)
+			if err != nil || c.ReadTimeout == 0 {
+				return conn, err
+			}
+			return &timeoutConn{conn, c.ReadTimeout}, nil
+		},
+		TLSClientConfig:       tc,
+		TLSHandshakeTimeout:   c.ConnectTimeout,
+		ResponseHeaderTimeout: c.ReadTimeout,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+// timeoutConn is a net.Conn whose reads fail if they stall for longer than
+// d.
+type timeoutConn struct {
+	net.Conn
+	d time.Duration
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.d)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// Fetch returns a reader for the contents of uri, which may be an http(s) or
+// file url, or a plain path.
+//
+// http(s) requests that fail with a network error or a transient status code
+// are retried according to the configured HTTPConfig.
+func Fetch(uri string) (io.ReadCloser, error) {
+	rc, _, err := FetchIfChanged(uri, Validators{})
+	return rc, err
+}
+
+// Validators identify the version of a fetched resource, so that it need
+// only be transferred again once it has changed.
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// FetchIfChanged is like Fetch, but returns a nil reader if the contents of
+// uri are unchanged since they were fetched with validators v. It also
+// returns the validators of the current contents.
+func FetchIfChanged(uri string, v Validators) (io.ReadCloser, Validators, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, v, errors.Wrap(err, "url parse")
+	}
+	switch u.Scheme {
+	case "file", "":
+		f, err := os.Open(filepath.FromSlash(u.Path))
+		if err != nil {
+			return nil, v, errors.Wrap(err, "open")
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, v, errors.Wrap(err, "stat")
+		}
+		nv := Validators{
+			ETag: fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+		}
+		if v.ETag == nv.ETag {
+			f.Close()
+			return nil, nv, nil
+		}
+		return f, nv, nil
+	case "http", "https":
+		h := http.Header{}
+		if v.ETag != "" {
+			h.Set("If-None-Match", v.ETag)
+		}
+		if v.LastModified != "" {
+			h.Set("If-Modified-Since", v.LastModified)
+		}
+		resp, err := get(uri, h)
+		if err != nil {
+			return nil, v, err
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			return nil, v, nil
+		}
+		nv := Validators{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		return resp.Body, nv, nil
+	}
+	return nil, v, errors.Errorf("unsupported url scheme %q", u.Scheme)
+}
+
+// get performs an http GET of uri with the additional headers h, retrying
+// transient failures, and returns the response if its status is 200 OK, or
+// one of the statuses that answer a conditional or range request.
+//
+// Responses are transparently decompressed if the server chooses to gzip
+// them.
+func get(uri string, h http.Header) (*http.Response, error) {
+	var err error
+	wait := httpConfig.Backoff
+	for i := 0; ; i++ {
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodGet, uri, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating request")
+		}
+		for k, vs := range h {
+			req.Header[k] = vs
+		}
+		var resp *http.Response
+		resp, err = authorize(req, uri).Do(req)
+		if err == nil {
+			switch resp.StatusCode {
+			case http.StatusOK, http.StatusNotModified,
+				http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+				return resp, nil
+			}
+			resp.Body.Close()
+			err = errors.Errorf("GET %v: %v", uri, resp.Status)
+			if !transient(resp.StatusCode) {
+				return nil, err
+			}
+		}
+		if i >= httpConfig.Retries {
+			break
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+	if httpConfig.Retries > 0 {
+		return nil, errors.Wrapf(err, "giving up after %d attempts", httpConfig.Retries+1)
+	}
+	return nil, err
+}
+
+// transient reports if a request that failed with status code might succeed
+// if retried.
+func transient(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= 500
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// quickRetries configures short timeouts and two quick retries for a test,
+// and returns a func that restores the defaults.
+func quickRetries(t *testing.T, read time.Duration) func() {
+	if err := ConfigureHTTP(HTTPConfig{
+		ConnectTimeout: time.Second,
+		ReadTimeout:    read,
+		Retries:        2,
+		Backoff:        time.Millisecond,
+	}); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	return func() {
+		ConfigureHTTP(DefaultHTTPConfig)
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	defer quickRetries(t, 100*time.Millisecond)()
+
+	mu := sync.Mutex{}
+	hits := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		case "/down":
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+			w.Write([]byte("ok"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	rc, err := Fetch(ts.URL + "/flaky")
+	if err != nil {
+		t.Fatalf("flaky: %v", err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got, want := string(b), "ok"; got != want {
+		t.Fatalf("body: got %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		path string
+		hits int
+	}{
+		{path: "/down", hits: 3},
+		{path: "/missing", hits: 1},
+		{path: "/slow", hits: 3},
+	}
+	for _, test := range tests {
+		if _, err := Fetch(ts.URL + test.path); err == nil {
+			t.Fatalf("%v: expected error", test.path)
+		}
+		mu.Lock()
+		got := hits[test.path]
+		mu.Unlock()
+		if want := test.hits; got != want {
+			t.Fatalf("%v attempts: got %v, want %v", test.path, got, want)
+		}
+	}
+
+	if err := ConfigureHTTP(HTTPConfig{CAFile: "/nonexistent"}); err == nil {
+		t.Fatalf("missing ca bundle accepted")
+	}
+}
+
+func TestConditionalPull(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	mu := sync.Mutex{}
+	version, full, unchanged := "1.0.0", 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		etag := fmt.Sprintf("%q", version)
+		if r.Header.Get("If-None-Match") == etag {
+			unchanged++
+		} else {
+			full++
+		}
+		av := fmt.Sprintf(`{"foo": {%q: {"name": "foo", "version": %q, "description": "foo"}}}`, version, version)
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "available.json", time.Time{}, bytes.NewReader([]byte(av)))
+	}))
+	defer ts.Close()
+
+	if err := AddRemotes(root, []string{ts.URL}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	tests := []struct {
+		version   string
+		full      int
+		unchanged int
+	}{
+		{version: "1.0.0", full: 1, unchanged: 0},
+		{version: "1.0.0", full: 1, unchanged: 1},
+		{version: "1.1.0", full: 2, unchanged: 1},
+		{version: "1.1.0", full: 2, unchanged: 2},
+	}
+	for _, test := range tests {
+		mu.Lock()
+		version = test.version
+		mu.Unlock()
+		if err := Pull(root); err != nil {
+			t.Fatalf("pull: %v", err)
+		}
+		mu.Lock()
+		f, u := full, unchanged
+		mu.Unlock()
+		if f != test.full || u != test.unchanged {
+			t.Fatalf("requests: got %v full %v unchanged, want %v full %v unchanged", f, u, test.full, test.unchanged)
+		}
+		a, err := LoadAvailable(root)
+		if err != nil {
+			t.Fatalf("load available: %v", err)
+		}
+		m, err := a.Get("foo", "")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if got, want := string(m.Version), test.version; got != want {
+			t.Fatalf("version: got %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+	"mcquay.me/pm"
+	"mcquay.me/pm/keyring"
+)
+
+const installedDir = "var/lib/pm/installed"
+
+// PackageInfo is everything known about a package, as returned by Info.
+type PackageInfo struct {
+	Name        pm.Name    `json:"name"`
+	Version     pm.Version `json:"version"`
+	Description string     `json:"description"`
+
+	// Available lists every available version of the package, oldest first.
+	Available []AvailablePackage `json:"available"`
+
+	Deps []string `json:"deps"`
+
+	// RequiredBy names the installed packages that depend on this one.
+	RequiredBy []pm.Name `json:"required_by"`
+
+	// Installed is nil unless the package is installed.
+	Installed *InstalledInfo `json:"installed"`
+}
+
+// InstalledInfo describes an installed package.
+type InstalledInfo struct {
+	Version pm.Version `json:"version"`
+	Date    time.Time  `json:"date"`
+
+	// Size is the total size, in bytes, of the Files installed. Files that
+	// have since been removed are not counted.
+	Size  int64 `json:"size"`
+	Files int   `json:"files"`
+
+	// Signers are the fingerprints of the known keys that signed the
+	// package, if its signatures were kept when it was installed.
+	Signers []string `json:"signers"`
+}
+
+// Info returns everything known about the package named by label (name or
+// name@version): its description, available versions and their remotes, its
+// dependencies and the installed packages that depend on it, and, if it is
+// installed, the installed version and when it was installed, its size, and
+// who signed it.
+func Info(root, label string) (PackageInfo, error) {
+	r := PackageInfo{}
+	n, v, err := pm.ParseLabel(label)
+	if err != nil {
+		return r, errors.Wrap(err, "parsing name/version")
+	}
+	av, err := LoadAvailable(root)
+	if err != nil {
+		return r, errors.Wrap(err, "loading available db")
+	}
+	in, err := loadi(root)
+	if err != nil {
+		return r, errors.Wrap(err, "loading installed db")
+	}
+	names, err := RemoteNames(root)
+	if err != nil {
+		return r, errors.Wrap(err, "loading remotes")
+	}
+
+	im, installed := in[n]
+	m, err := av.Get(n, v)
+	switch {
+	case installed && (v == "" || v == im.Version):
+		m = im
+	case err != nil:
+		return r, err
+	}
+	r.Name, r.Version, r.Description = m.Name, m.Version, m.Description
+
+	vers := pm.Versions{}
+	for ver := range av[n] {
+		vers = append(vers, ver)
+	}
+	sort.Sort(vers)
+	r.Available = []AvailablePackage{}
+	for _, ver := range vers {
+		r.Available = append(r.Available, available(av[n][ver], names))
+	}
+
+	r.Deps = m.Deps
+	if r.Deps == nil {
+		r.Deps = []string{}
+	}
+	r.RequiredBy = []pm.Name{}
+	for o := range in.Traverse() {
+		for _, d := range o.Deps {
+			dn, _, err := pm.ParseLabel(d)
+			if err == nil && dn == n {
+				r.RequiredBy = append(r.RequiredBy, o.Name)
+				break
+			}
+		}
+	}
+
+	if !installed {
+		return r, nil
+	}
+	i := &InstalledInfo{Version: im.Version}
+	fi, err := os.Stat(filepath.Join(root, installedDir, string(n), "meta.yaml"))
+	if err != nil {
+		return r, errors.Wrap(err, "stat installed metadata")
+	}
+	i.Date = fi.ModTime()
+	if i.Size, i.Files, err = installedSize(root, n); err != nil {
+		return r, errors.Wrap(err, "sizing installed files")
+	}
+	if i.Signers, err = installedSigners(root, n); err != nil {
+		return r, errors.Wrap(err, "checking signatures")
+	}
+	r.Installed = i
+	return r, nil
+}
+
+// installedSize returns the total size and number of the files installed by
+// the package named n that still exist.
+func installedSize(root string, n pm.Name) (int64, int, error) {
+	f, err := os.Open(filepath.Join(root, installedDir, string(n), "bom.sha256"))
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "opening bom")
+	}
+	defer f.Close()
+	bom, err := pm.ParseCS(f)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "parsing bom")
+	}
+	var size int64
+	files := 0
+	for k := range bom {
+		fi, err := os.Stat(filepath.Join(root, k))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "stat %v", k)
+		}
+		size += fi.Size()
+		files++
+	}
+	return size, files, nil
+}
+
+// installedSigners returns the fingerprints of the known keys that signed
+// the installed package named n, or none if its signatures were not kept.
+func installedSigners(root string, n pm.Name) ([]string, error) {
+	dir := filepath.Join(root, installedDir, string(n))
+	man, sig := filepath.Join(dir, "manifest.sha256"), filepath.Join(dir, "manifest.sha256.asc")
+	if !fs.Exists(man) || !fs.Exists(sig) {
+		return []string{}, nil
+	}
+	mf, err := os.Open(man)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening manifest")
+	}
+	defer mf.Close()
+	sf, err := os.Open(sig)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening manifest signature")
+	}
+	defer sf.Close()
+	return keyring.Signers(root, mf, sf)
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mcquay.me/pm"
+	"mcquay.me/pm/keyring"
+)
+
+func TestInfo(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	srv := filepath.Join(root, "srv", "stable")
+	if err := os.MkdirAll(srv, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	av := `{"foo": {
+		"1.0.0": {"name": "foo", "version": "1.0.0", "description": "frobnicator", "deps": ["bar@2.0.0"]},
+		"1.1.0": {"name": "foo", "version": "1.1.0", "description": "better frobnicator", "deps": ["bar"]}
+	}}`
+	if err := ioutil.WriteFile(filepath.Join(srv, "available.json"), []byte(av), 0644); err != nil {
+		t.Fatalf("writing available: %v", err)
+	}
+	if err := AddRemotes(root, []string{srv}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+
+	info := func(label string) PackageInfo {
+		i, err := Info(root, label)
+		if err != nil {
+			t.Fatalf("info %v: %v", label, err)
+		}
+		return i
+	}
+	want := PackageInfo{
+		Name:        "foo",
+		Version:     "1.1.0",
+		Description: "better frobnicator",
+		Available: []AvailablePackage{
+			{Name: "foo", Version: "1.0.0", Remote: "stable"},
+			{Name: "foo", Version: "1.1.0", Remote: "stable"},
+		},
+		Deps:       []string{"bar"},
+		RequiredBy: []pm.Name{},
+	}
+	if got := info("foo"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("info foo: got %+v, want %+v", got, want)
+	}
+	if _, err := Info(root, "foo@3.0.0"); err == nil {
+		t.Fatalf("described missing version")
+	}
+	if _, err := Info(root, "baz"); err == nil {
+		t.Fatalf("described missing package")
+	}
+
+	// install foo@1.0.0 by hand, as signed by a known key, and a package
+	// that depends on it.
+	if err := keyring.NewEd25519KeyPair(root, "c", "c@example.com", []byte("p")); err != nil {
+		t.Fatalf("keypair: %v", err)
+	}
+	key, err := keyring.FindSigner(root, "c@example.com", func() ([]byte, error) { return []byte("p"), nil })
+	if err != nil {
+		t.Fatalf("find signer: %v", err)
+	}
+	dir := filepath.Join(root, installedDir, "foo")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "bin"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	files := map[string]string{
+		filepath.Join(dir, "meta.yaml"):          "name: foo\n",
+		filepath.Join(dir, "bom.sha256"):         "x\tbin/foo\nx\tbin/foo-helper\n",
+		filepath.Join(root, "bin", "foo"):        strings.Repeat("f", 1536),
+		filepath.Join(root, "bin", "foo-helper"): strings.Repeat("h", 512),
+		filepath.Join(dir, "manifest.sha256"):    "x\troot.tar.bz2\n",
+	}
+	for fn, c := range files {
+		if err := ioutil.WriteFile(fn, []byte(c), 0644); err != nil {
+			t.Fatalf("writing %v: %v", fn, err)
+		}
+	}
+	sig := &bytes.Buffer{}
+	if err := key.Sign(strings.NewReader(files[filepath.Join(dir, "manifest.sha256")]), sig); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "manifest.sha256.asc"), sig.Bytes(), 0644); err != nil {
+		t.Fatalf("writing signature: %v", err)
+	}
+	a, err := LoadAvailable(root)
+	if err != nil {
+		t.Fatalf("load available: %v", err)
+	}
+	for _, m := range []pm.Meta{
+		a["foo"]["1.0.0"],
+		{Name: "qux", Version: "1.0.0", Description: "needs foo", Deps: []string{"foo@1.0.0"}},
+	} {
+		if err := AddInstalled(root, m); err != nil {
+			t.Fatalf("add installed: %v", err)
+		}
+	}
+
+	got := info("foo")
+	if got.Version != "1.0.0" || !reflect.DeepEqual(got.Deps, []string{"bar@2.0.0"}) {
+		t.Fatalf("installed version not described: %+v", got)
+	}
+	if want := []pm.Name{"qux"}; !reflect.DeepEqual(got.RequiredBy, want) {
+		t.Fatalf("required by: got %v, want %v", got.RequiredBy, want)
+	}
+	i := got.Installed
+	if i == nil {
+		t.Fatalf("not installed: %+v", got)
+	}
+	if i.Version != "1.0.0" || i.Date.IsZero() {
+		t.Fatalf("installed: %+v", i)
+	}
+	if i.Size != 2048 || i.Files != 2 {
+		t.Fatalf("size: got %v bytes in %v files, want 2048 in 2", i.Size, i.Files)
+	}
+	if want := []string{key.Fingerprint()}; !reflect.DeepEqual(i.Signers, want) {
+		t.Fatalf("signers: got %v, want %v", i.Signers, want)
+	}
+	if got, want := info("foo@1.1.0").Version, pm.Version("1.1.0"); got != want {
+		t.Fatalf("info foo@1.1.0: got version %v, want %v", got, want)
+	}
+
+	if err := os.Remove(filepath.Join(root, "bin", "foo-helper")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if i := info("foo").Installed; i.Size != 1536 || i.Files != 1 {
+		t.Fatalf("size: got %v bytes in %v files, want 1536 in 1", i.Size, i.Files)
+	}
+}
@@ -3,7 +3,6 @@ package db
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -46,43 +45,61 @@ func IsInstalled(root string, m pm.Meta) (bool, error) {
 	return r, nil
 }
 
-// ListInstalled pretty prints the installed database to w.
-func ListInstalled(root string, w io.Writer) error {
+// InstalledPackage is an installed package, as listed by ListInstalled.
+type InstalledPackage struct {
+	Name    pm.Name    `json:"name"`
+	Version pm.Version `json:"version"`
+
+	// Source is the url of the remote, or the path of the .pkg file, the
+	// package was installed from.
+	Source string `json:"source"`
+}
+
+// ListInstalled returns the installed packages.
+func ListInstalled(root string) ([]InstalledPackage, error) {
 	db, err := loadi(root)
 	if err != nil {
-		return errors.Wrap(err, "loading installed db")
+		return nil, errors.Wrap(err, "loading installed db")
 	}
 
+	r := []InstalledPackage{}
 	for m := range db.Traverse() {
-		fmt.Fprintf(w, "%v\t%v\t%v\n", m.Name, m.Version, m.Remote.String())
+		r = append(r, InstalledPackage{Name: m.Name, Version: m.Version, Source: m.Source()})
 	}
-	return nil
+	return r, nil
 }
 
-// ListInstalledFiles prints the contents of a package.
-func ListInstalledFiles(root string, w io.Writer, names []string) error {
+// InstalledFiles are the files installed by a package, relative to the root.
+type InstalledFiles struct {
+	Name  pm.Name  `json:"name"`
+	Files []string `json:"files"`
+}
+
+// ListInstalledFiles returns the contents of the named packages.
+func ListInstalledFiles(root string, names []string) ([]InstalledFiles, error) {
 	for _, name := range names {
 		ok, err := IsInstalled(root, pm.Meta{Name: pm.Name(name)})
 		if err != nil {
-			return errors.Wrap(err, "is installed")
+			return nil, errors.Wrap(err, "is installed")
 		}
 		if !ok {
-			return fmt.Errorf("%v not installed", name)
+			return nil, fmt.Errorf("%v not installed", name)
 		}
 	}
 
+	r := []InstalledFiles{}
 	for _, name := range names {
 		fn := filepath.Join(root, "var", "lib", "pm", "installed", name, "bom.sha256")
 		f, err := os.Open(fn)
 		if err != nil {
-			return errors.Wrapf(err, "opening %v's bom", name)
+			return nil, errors.Wrapf(err, "opening %v's bom", name)
 		}
 		bom, err := pm.ParseCS(f)
 		if err != nil {
-			return errors.Wrapf(err, "parsing %v's bom", name)
+			return nil, errors.Wrapf(err, "parsing %v's bom", name)
 		}
 		if err := f.Close(); err != nil {
-			return errors.Wrapf(err, "closing %v's bom", name)
+			return nil, errors.Wrapf(err, "closing %v's bom", name)
 		}
 
 		ks := []string{}
@@ -90,11 +107,9 @@ func ListInstalledFiles(root string, w io.Writer, names []string) error {
 			ks = append(ks, k)
 		}
 		sort.Strings(ks)
-		for _, k := range ks {
-			fmt.Fprintf(w, "%v\n", k)
-		}
+		r = append(r, InstalledFiles{Name: pm.Name(name), Files: ks})
 	}
-	return nil
+	return r, nil
 }
 
 func LoadInstalled(root string) (pm.Installed, error) {
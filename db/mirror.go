@@ -0,0 +1,110 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"mcquay.me/pm"
+	"mcquay.me/pm/keyring"
+)
+
+// pullIndex fetches the index of the remote r from u, one of its expanded
+// urls, unless it is unchanged since it was fetched with validators v, in
+// which case it returns nil.
+//
+// If r has mirrors the index must be signed to satisfy r's signature policy,
+// whichever of its urls u is.
+func pullIndex(root string, r Remote, u url.URL, v Validators) (*pulled, error) {
+	rc, nv, err := FetchIfChanged(u.String()+"/available.json", v)
+	if err != nil {
+		return nil, err
+	}
+	if rc == nil {
+		return nil, nil
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading available")
+	}
+	if len(r.Mirrors) > 0 {
+		if err := verifyIndex(root, r, u, b); err != nil {
+			return nil, errors.Wrap(err, "verifying index")
+		}
+	}
+
+	a := pm.Available{}
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, errors.Wrap(err, "decode remote available")
+	}
+	// packages are attributed to the remote, whichever url served them, and
+	// downloads fail over between its urls in the same way.
+	a.SetRemote(r.URL)
+	return &pulled{From: u.String(), Validators: nv, Available: a}, nil
+}
+
+// verifyIndex checks that b, the index served at u for the remote r, is
+// accompanied by a signature satisfying r's policy.
+func verifyIndex(root string, r Remote, u url.URL, b []byte) error {
+	rc, err := Fetch(u.String() + "/available.json.asc")
+	if err != nil {
+		return errors.Wrap(err, "fetching index signature")
+	}
+	defer rc.Close()
+	p, err := LoadPolicy(root, r.URL)
+	if err != nil {
+		return errors.Wrap(err, "loading signature policy")
+	}
+	return keyring.VerifyPolicy(root, bytes.NewReader(b), rc, p)
+}
+
+// VerifyMirrors checks that every mirror of the remote uri serves the same
+// index as the remote, signed to satisfy its signature policy.
+func VerifyMirrors(root, uri string) error {
+	db, i, err := find(root, uri)
+	if err != nil {
+		return err
+	}
+	r := db[i]
+	if err := Authenticate(root); err != nil {
+		return errors.Wrap(err, "authenticating")
+	}
+
+	sums := make([][sha256.Size]byte, len(r.URLs()))
+	err = Parallel(len(r.URLs()), func(j int) error {
+		u := Expand(r.URLs()[j])
+		rc, err := Fetch(u.String() + "/available.json")
+		if err != nil {
+			return errors.Wrapf(err, "fetching available from %q", u.String())
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return errors.Wrapf(err, "reading available from %q", u.String())
+		}
+		if err := verifyIndex(root, r, u, b); err != nil {
+			return errors.Wrapf(err, "verifying %q", u.String())
+		}
+		sums[j] = sha256.Sum256(b)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	errs := Errors{}
+	for j, m := range r.Mirrors {
+		if sums[j+1] != sums[0] {
+			errs = append(errs, fmt.Errorf("%q serves a different index than %q", m.String(), r.String()))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
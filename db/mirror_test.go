@@ -0,0 +1,245 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mcquay.me/pm/keyring"
+)
+
+func TestMirrors(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	if err := keyring.NewEd25519KeyPair(root, "c", "c@example.com", []byte("p")); err != nil {
+		t.Fatalf("keypair: %v", err)
+	}
+	key, err := keyring.FindSigner(root, "c@example.com", func() ([]byte, error) { return []byte("p"), nil })
+	if err != nil {
+		t.Fatalf("find signer: %v", err)
+	}
+	serve := func(dir, desc string, sign bool) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		av := fmt.Sprintf(`{"foo": {"1.0.0": {"name": "foo", "version": "1.0.0", "description": %q}}}`, desc)
+		if err := ioutil.WriteFile(filepath.Join(dir, "available.json"), []byte(av), 0644); err != nil {
+			t.Fatalf("writing available: %v", err)
+		}
+		if !sign {
+			return
+		}
+		sig := &bytes.Buffer{}
+		if err := key.Sign(strings.NewReader(av), sig); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "available.json.asc"), sig.Bytes(), 0644); err != nil {
+			t.Fatalf("writing signature: %v", err)
+		}
+	}
+
+	primary := filepath.Join(root, "srv", "primary")
+	unsigned := filepath.Join(root, "srv", "unsigned")
+	signed := filepath.Join(root, "srv", "signed")
+	serve(unsigned, "unsigned", false)
+	serve(signed, "signed", true)
+
+	if err := AddRemotes(root, []string{primary}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := AddMirrors(root, primary, []string{unsigned, signed}); err == nil {
+		t.Fatalf("added mirrors to a remote without a signature policy")
+	}
+	if err := SetPolicy(root, primary, keyring.Policy{Threshold: 1, Keys: []string{key.Fingerprint()}}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	if err := AddMirrors(root, primary, []string{unsigned, signed}); err != nil {
+		t.Fatalf("add mirrors: %v", err)
+	}
+	if err := AddMirrors(root, primary, []string{signed}); err == nil {
+		t.Fatalf("did not detect duplicate mirror")
+	}
+	if err := AddMirrors(root, signed, []string{unsigned}); err == nil {
+		t.Fatalf("added mirror to unconfigured remote")
+	}
+
+	// the primary is down and the first mirror's index is unsigned.
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	a, err := LoadAvailable(root)
+	if err != nil {
+		t.Fatalf("load available: %v", err)
+	}
+	m, err := a.Get("foo", "")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got, want := m.Description, "signed"; got != want {
+		t.Fatalf("description: got %v, want %v", got, want)
+	}
+	if got, want := m.Remote.String(), "file://"+filepath.ToSlash(primary); got != want {
+		t.Fatalf("remote: got %v, want %v", got, want)
+	}
+	us, err := Mirrors(root, m.Remote)
+	if err != nil {
+		t.Fatalf("mirrors: %v", err)
+	}
+	if got, want := len(us), 3; got != want {
+		t.Fatalf("urls: got %v, want %v", got, want)
+	}
+
+	rs, err := ListRemotes(root)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if got, want := rs[0].Mirrors, []string{"file://" + filepath.ToSlash(unsigned), "file://" + filepath.ToSlash(signed)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("mirrors: got %v, want %v", got, want)
+	}
+
+	if err := VerifyMirrors(root, primary); err == nil {
+		t.Fatalf("verified mirrors of unreachable remote")
+	}
+	if err := RemoveMirrors(root, primary, []string{unsigned}); err != nil {
+		t.Fatalf("remove mirror: %v", err)
+	}
+	serve(primary, "signed", true)
+	if err := VerifyMirrors(root, primary); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	serve(signed, "stale", true)
+	if err := VerifyMirrors(root, primary); err == nil {
+		t.Fatalf("did not detect mirror serving a different index")
+	}
+
+	desc := func() string {
+		a, err := LoadAvailable(root)
+		if err != nil {
+			t.Fatalf("load available: %v", err)
+		}
+		m, err := a.Get("foo", "")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		return m.Description
+	}
+	serve(primary, "fresh", true)
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if got, want := desc(), "fresh"; got != want {
+		t.Fatalf("description: got %v, want %v", got, want)
+	}
+
+	// the primary publishes a new index and goes down before clients pull
+	// it, but the mirror has it.
+	serve(primary, "newer", true)
+	serve(signed, "newer", true)
+	if err := os.RemoveAll(primary); err != nil {
+		t.Fatalf("remove primary: %v", err)
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull from mirror: %v", err)
+	}
+	if got, want := desc(), "newer"; got != want {
+		t.Fatalf("description: got %v, want %v", got, want)
+	}
+
+	// the primary is verified too, and the mirror is used instead.
+	serve(primary, "unsigned primary", false)
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if got, want := desc(), "newer"; got != want {
+		t.Fatalf("description: got %v, want %v", got, want)
+	}
+}
+
+func TestVerifyPrivateMirrors(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	if err := keyring.NewEd25519KeyPair(root, "c", "c@example.com", []byte("p")); err != nil {
+		t.Fatalf("keypair: %v", err)
+	}
+	key, err := keyring.FindSigner(root, "c@example.com", func() ([]byte, error) { return []byte("p"), nil })
+	if err != nil {
+		t.Fatalf("find signer: %v", err)
+	}
+	av := `{"foo": {"1.0.0": {"name": "foo", "version": "1.0.0", "description": "private"}}}`
+	sig := &bytes.Buffer{}
+	if err := key.Sign(strings.NewReader(av), sig); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch path.Base(r.URL.Path) {
+		case "available.json":
+			w.Write([]byte(av))
+		case "available.json.asc":
+			w.Write(sig.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	primary, mirror := ts.URL+"/primary", ts.URL+"/mirror"
+	if err := AddRemotes(root, []string{primary}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := SetPolicy(root, primary, keyring.Policy{Threshold: 1}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	if err := AddMirrors(root, primary, []string{mirror}); err != nil {
+		t.Fatalf("add mirrors: %v", err)
+	}
+	for _, u := range []string{primary, mirror} {
+		if err := SetCredential(root, u, Credential{Token: "t0ken"}); err != nil {
+			t.Fatalf("set credential: %v", err)
+		}
+	}
+
+	// nothing else has loaded the credentials.
+	authMu.Lock()
+	auths = nil
+	authMu.Unlock()
+	if err := VerifyMirrors(root, primary); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+}
+
+func TestLoadBareURLs(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	old := `[{"Scheme": "https", "Host": "pm.mcquay.me", "Path": "/darwin/amd64"}]`
+	if err := ioutil.WriteFile(filepath.Join(root, rn), []byte(old), 0644); err != nil {
+		t.Fatalf("writing remotes: %v", err)
+	}
+	db, err := load(root)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got, want := len(db), 1; got != want {
+		t.Fatalf("remotes: got %v, want %v", got, want)
+	}
+	if got, want := db[0].String(), "https://pm.mcquay.me/darwin/amd64"; got != want {
+		t.Fatalf("remote: got %v, want %v", got, want)
+	}
+	if got, want := db[0].Name, "amd64"; got != want {
+		t.Fatalf("name: got %v, want %v", got, want)
+	}
+}
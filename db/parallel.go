@@ -0,0 +1,53 @@
+package db
+
+import (
+	"strings"
+	"sync"
+)
+
+// Jobs is the maximum number of fetches run at once.
+var Jobs = 4
+
+// Errors collects the failures of a set of concurrent operations.
+type Errors []error
+
+func (e Errors) Error() string {
+	s := []string{}
+	for _, err := range e {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+// Parallel calls f for each i in [0, n), running at most Jobs at once. It
+// returns an Errors holding every failure, ordered by i, or nil.
+func Parallel(n int, f func(i int) error) error {
+	jobs := Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	errs := make([]error, n)
+	sem := make(chan bool, jobs)
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- true
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f(i)
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+
+	r := Errors{}
+	for _, err := range errs {
+		if err != nil {
+			r = append(r, err)
+		}
+	}
+	if len(r) == 0 {
+		return nil
+	}
+	return r
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+	Jobs = 3
+	defer func() { Jobs = 4 }()
+
+	mu := sync.Mutex{}
+	running, max := 0, 0
+	err := Parallel(10, func(i int) error {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if i%4 == 0 {
+			return fmt.Errorf("%d failed", i)
+		}
+		return nil
+	})
+	if max > Jobs {
+		t.Fatalf("concurrency: got %v, want at most %v", max, Jobs)
+	}
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("got %T, want Errors", err)
+	}
+	if got, want := errs.Error(), "0 failed; 4 failed; 8 failed"; got != want {
+		t.Fatalf("errors: got %q, want %q", got, want)
+	}
+
+	if err := Parallel(5, func(int) error { return nil }); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+}
+
+func TestPullOrder(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	remotes := []string{}
+	for i, desc := range []string{"first", "second", "third"} {
+		d := filepath.Join(root, "srv", desc)
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		av := fmt.Sprintf(`{
+			"foo": {"1.0.0": {"name": "foo", "version": "1.0.0", "description": %q}},
+			"bar%d": {"1.0.0": {"name": "bar%d", "version": "1.0.0", "description": %q}}
+		}`, desc, i, i, desc)
+		if err := ioutil.WriteFile(filepath.Join(d, "available.json"), []byte(av), 0644); err != nil {
+			t.Fatalf("writing available: %v", err)
+		}
+		remotes = append(remotes, d)
+	}
+	if err := AddRemotes(root, remotes); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	a, err := LoadAvailable(root)
+	if err != nil {
+		t.Fatalf("load available: %v", err)
+	}
+	m, err := a.Get("foo", "1.0.0")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got, want := m.Description, "first"; got != want {
+		t.Fatalf("collision: got %v, want %v", got, want)
+	}
+	if got, want := len(a), 4; got != want {
+		t.Fatalf("packages: got %v, want %v", got, want)
+	}
+
+	for _, d := range remotes[1:] {
+		if err := os.Remove(filepath.Join(d, "available.json")); err != nil {
+			t.Fatalf("remove: %v", err)
+		}
+	}
+	err = Pull(root)
+	if errs, ok := err.(Errors); !ok || len(errs) != 2 {
+		t.Fatalf("got %v, want two errors", err)
+	}
+}
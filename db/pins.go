@@ -0,0 +1,201 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+	"mcquay.me/pm"
+)
+
+const pinsn = "var/lib/pm/pins.json"
+
+// Pins records the packages that may only be sourced from a particular
+// remote, and the packages that installs should leave alone.
+type Pins struct {
+	// Remotes maps a package name to the url of the only remote it is
+	// installed from.
+	Remotes map[pm.Name]string `json:"remotes,omitempty"`
+
+	// Holds maps the name of a held package to the version it is held at,
+	// or "" if it is held at whatever version is installed.
+	Holds map[pm.Name]pm.Version `json:"holds,omitempty"`
+}
+
+// Held reports if installing version v of the package named n is prevented
+// by a hold, and otherwise the version it must be installed at, if any.
+func (p Pins) Held(n pm.Name, v pm.Version) (bool, pm.Version) {
+	hv, ok := p.Holds[n]
+	if !ok {
+		return false, v
+	}
+	if hv == "" || (v != "" && v != hv) {
+		return true, v
+	}
+	return false, hv
+}
+
+// Pin restricts the package named n to being sourced from the remote called,
+// or at, remote.
+func Pin(root string, n pm.Name, remote string) error {
+	db, i, err := find(root, remote)
+	if err != nil {
+		return err
+	}
+	p, err := LoadPins(root)
+	if err != nil {
+		return errors.Wrap(err, "loading pins")
+	}
+	p.Remotes[n] = db[i].String()
+	if err := savePins(root, p); err != nil {
+		return errors.Wrap(err, "saving pins")
+	}
+	return merge(root)
+}
+
+// Unpin allows the package named n to be sourced from any remote again.
+func Unpin(root string, n pm.Name) error {
+	p, err := LoadPins(root)
+	if err != nil {
+		return errors.Wrap(err, "loading pins")
+	}
+	if _, ok := p.Remotes[n]; !ok {
+		return fmt.Errorf("%q is not pinned", n)
+	}
+	delete(p.Remotes, n)
+	if err := savePins(root, p); err != nil {
+		return errors.Wrap(err, "saving pins")
+	}
+	return merge(root)
+}
+
+// Hold holds the package named by label (name or name@version), so that
+// installs skip it, or only install the given version.
+func Hold(root, label string) error {
+	n, v, err := pm.ParseLabel(label)
+	if err != nil {
+		return errors.Wrap(err, "parsing name/version")
+	}
+	p, err := LoadPins(root)
+	if err != nil {
+		return errors.Wrap(err, "loading pins")
+	}
+	p.Holds[n] = v
+	return savePins(root, p)
+}
+
+// Unhold releases the hold on the package named n.
+func Unhold(root string, n pm.Name) error {
+	p, err := LoadPins(root)
+	if err != nil {
+		return errors.Wrap(err, "loading pins")
+	}
+	if _, ok := p.Holds[n]; !ok {
+		return fmt.Errorf("%q is not held", n)
+	}
+	delete(p.Holds, n)
+	return savePins(root, p)
+}
+
+// PinEntry is a pinned or held package, as listed by ListPins.
+type PinEntry struct {
+	Name pm.Name `json:"name"`
+
+	// Kind is pin or hold.
+	Kind string `json:"kind"`
+
+	// Remote is the name, or url, of the remote a pinned package is sourced
+	// from.
+	Remote string `json:"remote,omitempty"`
+
+	// Version is the version a held package is held at, or "" if it is held
+	// at whatever version is installed.
+	Version pm.Version `json:"version,omitempty"`
+}
+
+// ListPins returns all pinned, then all held, packages.
+func ListPins(root string) ([]PinEntry, error) {
+	p, err := LoadPins(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading pins")
+	}
+	names, err := RemoteNames(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading remotes")
+	}
+
+	r := []PinEntry{}
+	ns := pm.Names{}
+	for n := range p.Remotes {
+		ns = append(ns, n)
+	}
+	sort.Sort(ns)
+	for _, n := range ns {
+		remote, ok := names[p.Remotes[n]]
+		if !ok {
+			remote = p.Remotes[n]
+		}
+		r = append(r, PinEntry{Name: n, Kind: "pin", Remote: remote})
+	}
+
+	ns = pm.Names{}
+	for n := range p.Holds {
+		ns = append(ns, n)
+	}
+	sort.Sort(ns)
+	for _, n := range ns {
+		r = append(r, PinEntry{Name: n, Kind: "hold", Version: p.Holds[n]})
+	}
+	return r, nil
+}
+
+// LoadPins returns the pinned and held packages.
+func LoadPins(root string) (Pins, error) {
+	r := Pins{
+		Remotes: map[pm.Name]string{},
+		Holds:   map[pm.Name]pm.Version{},
+	}
+	dbn := filepath.Join(root, pinsn)
+
+	if !fs.Exists(dbn) {
+		return r, nil
+	}
+
+	f, err := os.Open(dbn)
+	if err != nil {
+		return r, errors.Wrap(err, "open")
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return r, errors.Wrap(err, "decoding db")
+	}
+	if r.Remotes == nil {
+		r.Remotes = map[pm.Name]string{}
+	}
+	if r.Holds == nil {
+		r.Holds = map[pm.Name]pm.Version{}
+	}
+
+	return r, nil
+}
+
+func savePins(root string, db Pins) error {
+	f, err := os.Create(filepath.Join(root, pinsn))
+	if err != nil {
+		return errors.Wrap(err, "create")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(&db); err != nil {
+		return errors.Wrap(err, "encoding db")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close db")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"mcquay.me/pm"
+)
+
+func TestPins(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	stable := filepath.Join(root, "srv", "stable")
+	rc := filepath.Join(root, "srv", "testing")
+	for _, s := range []struct{ dir, version string }{
+		{stable, "1.0.0"},
+		{rc, "2.0.0-rc1"},
+	} {
+		if err := os.MkdirAll(s.dir, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		av := fmt.Sprintf(`{"foo": {%[1]q: {"name": "foo", "version": %[1]q, "description": "foo"}}}`, s.version)
+		if err := ioutil.WriteFile(filepath.Join(s.dir, "available.json"), []byte(av), 0644); err != nil {
+			t.Fatalf("writing available: %v", err)
+		}
+	}
+	if err := AddRemotes(root, []string{rc, stable}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+
+	version := func() string {
+		a, err := LoadAvailable(root)
+		if err != nil {
+			t.Fatalf("load available: %v", err)
+		}
+		m, err := a.Get("foo", "")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		return string(m.Version)
+	}
+	if got, want := version(), "2.0.0-rc1"; got != want {
+		t.Fatalf("unpinned: got %v, want %v", got, want)
+	}
+
+	if err := Pin(root, "foo", "missing"); err == nil {
+		t.Fatalf("pinned to unconfigured remote")
+	}
+	if err := Pin(root, "foo", "stable"); err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	if got, want := version(), "1.0.0"; got != want {
+		t.Fatalf("pinned: got %v, want %v", got, want)
+	}
+	// pins outlive pulls, and renames of the remote.
+	if err := RenameRemote(root, "stable", "prod"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if got, want := version(), "1.0.0"; got != want {
+		t.Fatalf("pinned after pull: got %v, want %v", got, want)
+	}
+
+	if err := Hold(root, "bar"); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	if err := Hold(root, "foo@1.0.0"); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	ps, err := ListPins(root)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	want := []PinEntry{
+		{Name: "foo", Kind: "pin", Remote: "prod"},
+		{Name: "bar", Kind: "hold"},
+		{Name: "foo", Kind: "hold", Version: "1.0.0"},
+	}
+	if !reflect.DeepEqual(ps, want) {
+		t.Fatalf("list: got %v, want %v", ps, want)
+	}
+
+	p, err := LoadPins(root)
+	if err != nil {
+		t.Fatalf("load pins: %v", err)
+	}
+	tests := []struct {
+		name    string
+		version string
+		held    bool
+		want    string
+	}{
+		{"bar", "", true, ""},
+		{"bar", "1.0.0", true, "1.0.0"},
+		{"foo", "", false, "1.0.0"},
+		{"foo", "1.0.0", false, "1.0.0"},
+		{"foo", "2.0.0-rc1", true, "2.0.0-rc1"},
+		{"baz", "", false, ""},
+	}
+	for _, test := range tests {
+		held, v := p.Held(pm.Name(test.name), pm.Version(test.version))
+		if held != test.held || string(v) != test.want {
+			t.Fatalf("held(%v, %q): got %v %q, want %v %q", test.name, test.version, held, v, test.held, test.want)
+		}
+	}
+
+	if err := Unhold(root, "baz"); err == nil {
+		t.Fatalf("released package that was not held")
+	}
+	if err := Unpin(root, "foo"); err != nil {
+		t.Fatalf("unpin: %v", err)
+	}
+	if got, want := version(), "2.0.0-rc1"; got != want {
+		t.Fatalf("unpinned: got %v, want %v", got, want)
+	}
+	ps, err = ListPins(root)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	for _, p := range ps {
+		if p.Kind == "pin" {
+			t.Fatalf("pin listed after unpin: %v", ps)
+		}
+	}
+}
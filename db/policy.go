@@ -0,0 +1,118 @@
+package db
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+	"mcquay.me/pm/keyring"
+)
+
+const pn = "var/lib/pm/policies.json"
+
+// Policies maps remote URI to the signature policy for packages it serves.
+type Policies map[string]keyring.Policy
+
+// SetPolicy configures the signature policy enforced when installing packages
+// from the remote called, or at, uri.
+func SetPolicy(root, uri string, p keyring.Policy) error {
+	if _, err := p.Valid(); err != nil {
+		return errors.Wrap(err, "invalid policy")
+	}
+	db, i, err := find(root, uri)
+	if err != nil {
+		return err
+	}
+
+	ps, err := loadp(root)
+	if err != nil {
+		return errors.Wrap(err, "loading policies")
+	}
+	ps[db[i].String()] = p
+	return savep(root, ps)
+}
+
+// LoadPolicy returns the signature policy for packages from u, which
+// defaults to requiring a single valid signature.
+func LoadPolicy(root string, u url.URL) (keyring.Policy, error) {
+	ps, err := loadp(root)
+	if err != nil {
+		return keyring.Policy{}, errors.Wrap(err, "loading policies")
+	}
+	su := strip(u)
+	if p, ok := ps[su.String()]; ok {
+		return p, nil
+	}
+	return keyring.Policy{Threshold: 1}, nil
+}
+
+// PolicyEntry is the signature policy of a remote, as listed by
+// ListPolicies.
+type PolicyEntry struct {
+	URL       string   `json:"url"`
+	Threshold int      `json:"threshold"`
+	Keys      []string `json:"keys"`
+}
+
+// ListPolicies returns all configured signature policies.
+func ListPolicies(root string) ([]PolicyEntry, error) {
+	ps, err := loadp(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading policies")
+	}
+	us := []string{}
+	for u := range ps {
+		us = append(us, u)
+	}
+	sort.Strings(us)
+	r := []PolicyEntry{}
+	for _, u := range us {
+		ks := ps[u].Keys
+		if ks == nil {
+			ks = []string{}
+		}
+		r = append(r, PolicyEntry{URL: u, Threshold: ps[u].Threshold, Keys: ks})
+	}
+	return r, nil
+}
+
+func loadp(root string) (Policies, error) {
+	r := Policies{}
+	dbn := filepath.Join(root, pn)
+
+	if !fs.Exists(dbn) {
+		return r, nil
+	}
+
+	f, err := os.Open(dbn)
+	if err != nil {
+		return r, errors.Wrap(err, "open")
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return r, errors.Wrap(err, "decoding db")
+	}
+
+	return r, nil
+}
+
+func savep(root string, db Policies) error {
+	f, err := os.Create(filepath.Join(root, pn))
+	if err != nil {
+		return errors.Wrap(err, "create")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(&db); err != nil {
+		return errors.Wrap(err, "encoding db")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close db")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"mcquay.me/pm/keyring"
+)
+
+func TestPolicy(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	uri := "https://pm.mcquay.me/darwin/amd64/stable"
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("parsing url: %v", err)
+	}
+
+	p, err := LoadPolicy(root, *u)
+	if err != nil {
+		t.Fatalf("load policy: %v", err)
+	}
+	if got, want := p.Threshold, 1; got != want {
+		t.Fatalf("default threshold: got %v, want %v", got, want)
+	}
+
+	fps := []string{strings.Repeat("a", 40), strings.Repeat("b", 40), strings.Repeat("c", 40)}
+	p, err = keyring.NewPolicy(2, fps)
+	if err != nil {
+		t.Fatalf("new policy: %v", err)
+	}
+	if err := SetPolicy(root, uri, p); err == nil {
+		t.Fatalf("should not be able to set policy for unconfigured remote")
+	}
+	if err := AddRemotes(root, []string{uri}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := SetPolicy(root, uri, p); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+
+	p, err = LoadPolicy(root, *u)
+	if err != nil {
+		t.Fatalf("load policy: %v", err)
+	}
+	if got, want := p.Threshold, 2; got != want {
+		t.Fatalf("threshold: got %v, want %v", got, want)
+	}
+	if got, want := p.Keys[0], strings.Repeat("A", 40); got != want {
+		t.Fatalf("fingerprint not normalized: got %v, want %v", got, want)
+	}
+
+	if _, err := keyring.NewPolicy(4, fps); err == nil {
+		t.Fatalf("should not be able to require more signatures than keys")
+	}
+	if _, err := keyring.NewPolicy(1, []string{"ABCDEF01"}); err == nil {
+		t.Fatalf("should not accept short key ids")
+	}
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress renders the progress of concurrent Downloads, and their total, to
+// a terminal.
+type Progress struct {
+	w     io.Writer
+	begin time.Time
+	stop  chan bool
+	wg    sync.WaitGroup
+
+	mu    sync.Mutex
+	items []*transfer
+	lines int
+}
+
+// transfer is the progress of a single Download.
+type transfer struct {
+	p     *Progress
+	name  string
+	begin time.Time
+
+	// start is the offset the transfer resumed at, done how far it has got,
+	// and size its total size, or -1 if unknown.
+	start, done, size int64
+	finished          bool
+}
+
+func (t *transfer) Write(b []byte) (int, error) {
+	t.p.mu.Lock()
+	t.done += int64(len(b))
+	t.p.mu.Unlock()
+	return len(b), nil
+}
+
+// NewProgress returns a Progress that redraws itself on w every d until it
+// is closed.
+func NewProgress(w io.Writer, d time.Duration) *Progress {
+	p := &Progress{
+		w:     w,
+		begin: time.Now(),
+		stop:  make(chan bool),
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		t := time.NewTicker(d)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.render()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Close stops redrawing p, after drawing it a final time.
+func (p *Progress) Close() {
+	close(p.stop)
+	p.wg.Wait()
+	p.render()
+}
+
+// track returns the transfer called name, which is (re)starting at offset
+// start of size bytes.
+func (p *Progress) track(name string, start, size int64) *transfer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.items {
+		if t.name == name {
+			t.begin, t.start, t.done, t.size = time.Now(), start, start, size
+			return t
+		}
+	}
+	t := &transfer{
+		p:     p,
+		name:  name,
+		begin: time.Now(),
+		start: start,
+		done:  start,
+		size:  size,
+	}
+	p.items = append(p.items, t)
+	return t
+}
+
+// finish marks the transfer called name as complete. It is safe to call on
+// a nil Progress.
+func (p *Progress) finish(name string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.items {
+		if t.name == name {
+			t.finished = true
+		}
+	}
+}
+
+// render redraws the unfinished transfers, followed by the total, over what
+// was previously drawn.
+func (p *Progress) render() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	buf := &bytes.Buffer{}
+	if p.lines > 0 {
+		fmt.Fprintf(buf, "\033[%dA", p.lines)
+	}
+	fmt.Fprint(buf, "\r\033[J")
+
+	lines := 0
+	var start, done, size int64
+	for _, t := range p.items {
+		start += t.start
+		done += t.done
+		if t.size < 0 || size < 0 {
+			size = -1
+		} else {
+			size += t.size
+		}
+		if t.finished {
+			continue
+		}
+		fmt.Fprintf(buf, "%-32s %v\n", t.name, status(t.done-t.start, t.done, t.size, now.Sub(t.begin)))
+		lines++
+	}
+	fmt.Fprintf(buf, "%-32s %v\n", "total", status(done-start, done, size, now.Sub(p.begin)))
+	lines++
+
+	p.lines = lines
+	p.w.Write(buf.Bytes())
+}
+
+// status describes a transfer that has moved n bytes in d, and is at done of
+// size bytes.
+func status(n, done, size int64, d time.Duration) string {
+	rate := float64(0)
+	if d > 0 {
+		rate = float64(n) / d.Seconds()
+	}
+	if size < 0 {
+		return fmt.Sprintf("%9v  %9v/s", Bytesize(done), Bytesize(int64(rate)))
+	}
+	eta := "--"
+	if done >= size {
+		eta = "done"
+	} else if rate > 0 {
+		eta = (time.Duration(float64(size-done)/rate) * time.Second).String()
+	}
+	return fmt.Sprintf("%9v / %-9v  %9v/s  ETA %v", Bytesize(done), Bytesize(size), Bytesize(int64(rate)), eta)
+}
+
+// Bytesize formats n bytes for humans.
+func Bytesize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -3,21 +3,77 @@ package db
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"mcquay.me/fs"
 )
 
-// DB is a slice of available URI
-type DB []url.URL
+// Remote is a configured source of packages: the url of a namespace, and
+// the urls of mirrors serving copies of it, in the order they are tried.
+type Remote struct {
+	// Name identifies the remote in place of its url.
+	Name string `json:"name"`
+
+	// Priority orders remotes: those with smaller priority values are pulled
+	// first, and so win when remotes offer the same package.
+	Priority int `json:"priority"`
+
+	// Disabled remotes are not pulled.
+	Disabled bool `json:"disabled,omitempty"`
+
+	URL     url.URL   `json:"url"`
+	Mirrors []url.URL `json:"mirrors,omitempty"`
+}
+
+func (r Remote) String() string {
+	return r.URL.String()
+}
+
+// URLs returns the url of r followed by those of its mirrors.
+func (r Remote) URLs() []url.URL {
+	return append([]url.URL{r.URL}, r.Mirrors...)
+}
+
+// DB is a slice of configured remotes, ordered by priority.
+type DB []Remote
+
+func (db DB) Len() int      { return len(db) }
+func (db DB) Swap(a, b int) { db[a], db[b] = db[b], db[a] }
+func (db DB) Less(a, b int) bool {
+	return db[a].Priority < db[b].Priority
+}
+
+// enabled returns the remotes in db that are not disabled.
+func (db DB) enabled() DB {
+	r := DB{}
+	for _, d := range db {
+		if !d.Disabled {
+			r = append(r, d)
+		}
+	}
+	return r
+}
+
+// priorityStep separates the default priorities of successively added
+// remotes, leaving room to slot others between them.
+const priorityStep = 10
+
+// validName matches acceptable remote names.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
 
 const rn = "var/lib/pm/remotes.json"
 
-// AddRemotes appends the provided uri to the list of configured remotes.
+// AddRemotes appends the provided uri to the list of configured remotes. Each
+// is named after the last element of its path, and given a larger priority
+// value than any already configured, so it is pulled last.
 func AddRemotes(root string, uris []string) error {
 	db, err := load(root)
 	if err != nil {
@@ -30,22 +86,121 @@ func AddRemotes(root string, uris []string) error {
 	}
 
 	for _, uri := range uris {
-		pu, err := url.Parse(uri)
+		u, err := parseRemote(uri)
 		if err != nil {
-			return errors.Wrap(err, "url parse")
+			return errors.Wrapf(err, "parsing %q", uri)
 		}
 
-		u := strip(*pu)
-
 		if _, ok := dbm[u.String()]; ok {
 			return fmt.Errorf("%q already in db", u.String())
 		}
-		db = append(db, u)
+		dbm[u.String()] = true
+		db = append(db, Remote{
+			Name:     db.newName(u),
+			Priority: db.nextPriority(),
+			URL:      u,
+		})
 	}
 
 	return save(root, db)
 }
 
+// RenameRemote renames the remote called, or at, name.
+func RenameRemote(root, name, to string) error {
+	if !validName.MatchString(to) {
+		return fmt.Errorf("invalid remote name %q", to)
+	}
+	db, i, err := find(root, name)
+	if err != nil {
+		return err
+	}
+	for j, r := range db {
+		if j != i && r.Name == to {
+			return fmt.Errorf("a remote is already named %q", to)
+		}
+	}
+	db[i].Name = to
+	return save(root, db)
+}
+
+// SetPriority sets the priority of the remote called, or at, name.
+func SetPriority(root, name string, priority int) error {
+	db, i, err := find(root, name)
+	if err != nil {
+		return err
+	}
+	db[i].Priority = priority
+	return save(root, db)
+}
+
+// EnableRemote enables or disables the remote called, or at, name.
+func EnableRemote(root, name string, enabled bool) error {
+	db, i, err := find(root, name)
+	if err != nil {
+		return err
+	}
+	db[i].Disabled = !enabled
+	return save(root, db)
+}
+
+// lookup returns the index of the remote called, or at, name.
+func (db DB) lookup(name string) (int, error) {
+	for i, r := range db {
+		if r.Name == name {
+			return i, nil
+		}
+	}
+	u, err := parseRemote(name)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither a remote name nor a valid url: %v", name, err)
+	}
+	for i, r := range db {
+		if r.String() == u.String() {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a configured remote", name)
+}
+
+// newName returns an unused name for a remote at u.
+func (db DB) newName(u url.URL) string {
+	base := strings.Trim(path.Base(strings.TrimSuffix(u.Path, "/")), "{}")
+	if base == "." || base == "/" || base == "" {
+		base = u.Host
+	}
+	base = strings.Map(func(r rune) rune {
+		if r < 128 && validName.MatchString("a"+string(r)) {
+			return r
+		}
+		return '-'
+	}, base)
+	if !validName.MatchString(base) {
+		base = "remote"
+	}
+
+	used := map[string]bool{}
+	for _, r := range db {
+		used[r.Name] = true
+	}
+	n := base
+	for i := 2; used[n]; i++ {
+		n = fmt.Sprintf("%v-%d", base, i)
+	}
+	return n
+}
+
+// nextPriority returns a priority value larger than that of every remote in
+// db.
+func (db DB) nextPriority() int {
+	p := 0
+	for _, r := range db {
+		if r.Priority > p {
+			p = r.Priority
+		}
+	}
+	return p + priorityStep
+}
+
 // RemoveRemotes removes the given uri from the list of configured remotes.
 func RemoveRemotes(root string, uris []string) error {
 	db, err := load(root)
@@ -55,14 +210,12 @@ func RemoveRemotes(root string, uris []string) error {
 
 	rms := map[string]bool{}
 	for _, uri := range uris {
-		pu, err := url.Parse(uri)
+		i, err := db.lookup(uri)
 		if err != nil {
-			return errors.Wrap(err, "url parse")
+			return err
 		}
 
-		u := strip(*pu)
-
-		rms[u.String()] = true
+		rms[db[i].String()] = true
 	}
 
 	o := DB{}
@@ -76,19 +229,194 @@ func RemoveRemotes(root string, uris []string) error {
 		return errors.New("found no matching remotes")
 	}
 
+	ps, err := loadp(root)
+	if err != nil {
+		return errors.Wrap(err, "loading policies")
+	}
+	if len(ps) > 0 {
+		for u := range rms {
+			delete(ps, u)
+		}
+		if err := savep(root, ps); err != nil {
+			return errors.Wrap(err, "saving policies")
+		}
+	}
+
+	cs, err := loadc(root)
+	if err != nil {
+		return errors.Wrap(err, "loading credentials")
+	}
+	if len(cs) > 0 {
+		for _, d := range db {
+			if !rms[d.String()] {
+				continue
+			}
+			for _, u := range d.URLs() {
+				delete(cs, u.String())
+			}
+		}
+		if err := savec(root, cs); err != nil {
+			return errors.Wrap(err, "saving credentials")
+		}
+	}
+
 	return save(root, o)
 }
 
-// ListRemotes prints all configured remotes to w.
-func ListRemotes(root string, w io.Writer) error {
+// AddMirrors appends the provided mirrors to those of the remote uri.
+//
+// The indexes of a remote with mirrors must be signed, so it must already
+// have a signature policy; see SetPolicy.
+func AddMirrors(root, uri string, mirrors []string) error {
+	db, i, err := find(root, uri)
+	if err != nil {
+		return err
+	}
+	ps, err := loadp(root)
+	if err != nil {
+		return errors.Wrap(err, "loading policies")
+	}
+	if _, ok := ps[db[i].String()]; !ok {
+		return fmt.Errorf("%q has no signature policy, which its signed indexes must satisfy once it has mirrors; set one with pm remote policy", db[i].String())
+	}
+
+	seen := map[string]bool{}
+	for _, u := range db[i].URLs() {
+		seen[u.String()] = true
+	}
+	for _, m := range mirrors {
+		u, err := parseRemote(m)
+		if err != nil {
+			return errors.Wrapf(err, "parsing %q", m)
+		}
+		if seen[u.String()] {
+			return fmt.Errorf("%q is already a url of %q", u.String(), db[i].String())
+		}
+		seen[u.String()] = true
+		db[i].Mirrors = append(db[i].Mirrors, u)
+	}
+	return save(root, db)
+}
+
+// RemoveMirrors removes the provided mirrors from those of the remote uri.
+func RemoveMirrors(root, uri string, mirrors []string) error {
+	db, i, err := find(root, uri)
+	if err != nil {
+		return err
+	}
+
+	rms := map[string]bool{}
+	for _, m := range mirrors {
+		u, err := parseRemote(m)
+		if err != nil {
+			return errors.Wrapf(err, "parsing %q", m)
+		}
+		rms[u.String()] = true
+	}
+	ms := []url.URL{}
+	for _, m := range db[i].Mirrors {
+		if !rms[m.String()] {
+			ms = append(ms, m)
+		}
+	}
+	if len(ms)+len(rms) != len(db[i].Mirrors) {
+		return fmt.Errorf("not all of %v are mirrors of %q", mirrors, db[i].String())
+	}
+	db[i].Mirrors = ms
+
+	cs, err := loadc(root)
+	if err != nil {
+		return errors.Wrap(err, "loading credentials")
+	}
+	if len(cs) > 0 {
+		for u := range rms {
+			delete(cs, u)
+		}
+		if err := savec(root, cs); err != nil {
+			return errors.Wrap(err, "saving credentials")
+		}
+	}
+	return save(root, db)
+}
+
+// Mirrors returns the urls packages from the remote u may be fetched from,
+// in the order they should be tried, with their placeholders expanded.
+func Mirrors(root string, u url.URL) ([]url.URL, error) {
 	db, err := load(root)
 	if err != nil {
-		return errors.Wrap(err, "loading")
+		return nil, errors.Wrap(err, "loading")
 	}
-	for _, u := range db {
-		fmt.Fprintf(w, "%s\n", u.String())
+	su := strip(u)
+	for _, r := range db {
+		if r.String() == su.String() {
+			us := []url.URL{}
+			for _, ru := range r.URLs() {
+				us = append(us, Expand(ru))
+			}
+			return us, nil
+		}
 	}
-	return nil
+	return []url.URL{Expand(u)}, nil
+}
+
+// RemoteEntry is a configured remote, as listed by ListRemotes.
+type RemoteEntry struct {
+	Name     string   `json:"name"`
+	Priority int      `json:"priority"`
+	Enabled  bool     `json:"enabled"`
+	URL      string   `json:"url"`
+	Mirrors  []string `json:"mirrors"`
+}
+
+// ListRemotes returns all configured remotes, and their mirrors, in
+// priority order.
+func ListRemotes(root string) ([]RemoteEntry, error) {
+	db, err := load(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading")
+	}
+	r := []RemoteEntry{}
+	for _, d := range db {
+		ms := []string{}
+		for _, m := range d.Mirrors {
+			ms = append(ms, template(m))
+		}
+		r = append(r, RemoteEntry{
+			Name:     d.Name,
+			Priority: d.Priority,
+			Enabled:  !d.Disabled,
+			URL:      template(d.URL),
+			Mirrors:  ms,
+		})
+	}
+	return r, nil
+}
+
+// RemoteNames returns the names of the configured remotes keyed by url.
+func RemoteNames(root string) (map[string]string, error) {
+	db, err := load(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading")
+	}
+	r := map[string]string{}
+	for _, d := range db {
+		r[d.String()] = d.Name
+	}
+	return r, nil
+}
+
+// find returns the remotes and the index of the remote called, or at, name
+// among them.
+func find(root, name string) (DB, int, error) {
+	db, err := load(root)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "loading")
+	}
+	i, err := db.lookup(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return db, i, nil
 }
 
 func load(root string) (DB, error) {
@@ -103,15 +431,40 @@ func load(root string) (DB, error) {
 	if err != nil {
 		return r, errors.Wrap(err, "open")
 	}
+	defer f.Close()
 
-	if err := json.NewDecoder(f).Decode(&r); err != nil {
+	raw := []json.RawMessage{}
+	if err := json.NewDecoder(f).Decode(&raw); err != nil {
 		return r, errors.Wrap(err, "decoding db")
 	}
+	for _, b := range raw {
+		rm := Remote{}
+		if err := json.Unmarshal(b, &rm); err != nil {
+			return r, errors.Wrap(err, "decoding remote")
+		}
+		if rm.URL.Scheme == "" {
+			// remotes used to be stored as bare urls.
+			if err := json.Unmarshal(b, &rm.URL); err != nil {
+				return r, errors.Wrap(err, "decoding remote url")
+			}
+		}
+		r = append(r, rm)
+	}
+
+	// remotes used to be unnamed, and ordered by position alone.
+	for i := range r {
+		if r[i].Name == "" {
+			r[i].Name = r.newName(r[i].URL)
+			r[i].Priority = (i + 1) * priorityStep
+		}
+	}
+	sort.Stable(r)
 
 	return r, nil
 }
 
 func save(root string, db DB) error {
+	sort.Stable(db)
 	f, err := os.Create(filepath.Join(root, rn))
 	if err != nil {
 		return errors.Wrap(err, "create")
@@ -130,6 +483,66 @@ func save(root string, db DB) error {
 	return nil
 }
 
+// OS and Arch replace the {os} and {arch} placeholders in the paths of
+// remote urls when they are fetched from.
+var (
+	OS   = runtime.GOOS
+	Arch = runtime.GOARCH
+)
+
+// placeholders lists those that may appear in the path of a remote url.
+var placeholders = []string{"{os}", "{arch}"}
+
+// Expand returns u with the placeholders in its path replaced by OS and Arch.
+func Expand(u url.URL) url.URL {
+	u.Path = strings.NewReplacer("{os}", OS, "{arch}", Arch).Replace(u.Path)
+	return u
+}
+
+// template returns u as configured, with its placeholders unescaped.
+func template(u url.URL) string {
+	s := u.String()
+	for _, p := range placeholders {
+		s = strings.Replace(s, url.PathEscape(p), p, -1)
+	}
+	return s
+}
+
+// parseRemote parses uri as a remote. Remotes are http(s) or file urls; plain
+// paths are turned into absolute file urls.
+func parseRemote(uri string) (url.URL, error) {
+	pu, err := url.Parse(uri)
+	if err != nil {
+		return url.URL{}, errors.Wrap(err, "url parse")
+	}
+	u := strip(*pu)
+	p := u.Path
+	for _, ph := range placeholders {
+		p = strings.Replace(p, ph, "", -1)
+	}
+	if strings.ContainsAny(p, "{}") {
+		return u, fmt.Errorf("unknown placeholder in %q; only %v are supported", u.Path, strings.Join(placeholders, " and "))
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "file", "":
+		if u.Host != "" && u.Host != "localhost" {
+			return u, fmt.Errorf("file urls cannot have a host (%q); use file:///path", u.Host)
+		}
+		if u.Path == "" {
+			return u, errors.New("empty path")
+		}
+		p, err := filepath.Abs(filepath.FromSlash(u.Path))
+		if err != nil {
+			return u, errors.Wrap(err, "absolute path")
+		}
+		u = url.URL{Scheme: "file", Path: filepath.ToSlash(p)}
+	default:
+		return u, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	return u, nil
+}
+
 // strip removes all fields we don't currently need.
 func strip(u url.URL) url.URL {
 	return url.URL{
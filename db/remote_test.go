@@ -1,9 +1,11 @@
 package db
 
 import (
-	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -143,15 +145,244 @@ func TestList(t *testing.T) {
 		t.Fatalf("add: %v", err)
 	}
 
-	buf := &bytes.Buffer{}
-	if err := ListRemotes(root, buf); err != nil {
+	rs, err := ListRemotes(root)
+	if err != nil {
 		t.Fatalf("list: %v", err)
 	}
 
 	for _, u := range uris {
-		if !strings.Contains(buf.String(), u) {
-			t.Fatalf("could not find %q in output\n%v", u, buf.String())
+		found := false
+		for _, r := range rs {
+			if r.URL == u {
+				found = true
+			}
 		}
+		if !found {
+			t.Fatalf("could not find %q in output\n%v", u, rs)
+		}
+	}
+
+}
+
+func TestFileRemotes(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	srv := filepath.Join(root, "srv", "stable")
+	if err := os.MkdirAll(srv, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	av := `{"foo": {"1.0.0": {"name": "foo", "version": "1.0.0", "description": "test"}}}`
+	if err := ioutil.WriteFile(filepath.Join(srv, "available.json"), []byte(av), 0644); err != nil {
+		t.Fatalf("writing available: %v", err)
 	}
 
+	for _, bad := range []string{"ftp://example.com/pm", "file://example.com/pm"} {
+		if err := AddRemotes(root, []string{bad}); err == nil {
+			t.Fatalf("added bad remote %q", bad)
+		}
+	}
+
+	if err := AddRemotes(root, []string{srv}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := AddRemotes(root, []string{"file://" + srv}); err == nil {
+		t.Fatalf("did not detect duplicate of plain path")
+	}
+	if err := Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+
+	a, err := LoadAvailable(root)
+	if err != nil {
+		t.Fatalf("load available: %v", err)
+	}
+	m, err := a.Get("foo", "")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got, want := m.URL(), "file://"+filepath.Join(srv, "foo-1.0.0.pkg"); got != want {
+		t.Fatalf("url: got %v, want %v", got, want)
+	}
+
+	if err := RemoveRemotes(root, []string{"file://" + srv}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+}
+
+func TestNamedRemotes(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	// a remotes.json from before remotes had names or priorities.
+	old := `[
+		{"url": {"Scheme": "https", "Host": "a.example.com", "Path": "/linux/amd64/stable"}},
+		{"url": {"Scheme": "https", "Host": "b.example.com", "Path": "/linux/amd64/stable"}}
+	]`
+	if err := ioutil.WriteFile(filepath.Join(root, rn), []byte(old), 0644); err != nil {
+		t.Fatalf("writing remotes: %v", err)
+	}
+	if err := AddRemotes(root, []string{"https://c.example.com/"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	names := func() string {
+		db, err := load(root)
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		ns := []string{}
+		for _, r := range db {
+			s := fmt.Sprintf("%v:%v", r.Name, r.Priority)
+			if r.Disabled {
+				s += ":disabled"
+			}
+			ns = append(ns, s)
+		}
+		return strings.Join(ns, ",")
+	}
+	if got, want := names(), "stable:10,stable-2:20,c.example.com:30"; got != want {
+		t.Fatalf("migrated: got %v, want %v", got, want)
+	}
+
+	if err := RenameRemote(root, "stable-2", "stable"); err == nil {
+		t.Fatalf("renamed to duplicate name")
+	}
+	if err := RenameRemote(root, "stable-2", "b/stable"); err == nil {
+		t.Fatalf("renamed to invalid name")
+	}
+	if err := RenameRemote(root, "https://b.example.com/linux/amd64/stable", "b"); err != nil {
+		t.Fatalf("rename by url: %v", err)
+	}
+	if err := SetPriority(root, "b", 5); err != nil {
+		t.Fatalf("priority: %v", err)
+	}
+	if err := EnableRemote(root, "stable", false); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	if err := SetPriority(root, "missing", 1); err == nil {
+		t.Fatalf("set priority of missing remote")
+	}
+	if got, want := names(), "b:5,stable:10:disabled,c.example.com:30"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if err := RemoveRemotes(root, []string{"c.example.com"}); err != nil {
+		t.Fatalf("remove by name: %v", err)
+	}
+	if got, want := names(), "b:5,stable:10:disabled"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestPullPriority(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	for _, desc := range []string{"first", "second"} {
+		d := filepath.Join(root, "srv", desc)
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		av := fmt.Sprintf(`{"foo": {"1.0.0": {"name": "foo", "version": "1.0.0", "description": %q}}}`, desc)
+		if err := ioutil.WriteFile(filepath.Join(d, "available.json"), []byte(av), 0644); err != nil {
+			t.Fatalf("writing available: %v", err)
+		}
+		if err := AddRemotes(root, []string{d}); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+
+	tests := []struct {
+		op   func() error
+		want string
+	}{
+		{op: func() error { return nil }, want: "first"},
+		{op: func() error { return SetPriority(root, "second", 1) }, want: "second"},
+		{op: func() error { return EnableRemote(root, "second", false) }, want: "first"},
+	}
+	for _, test := range tests {
+		if err := test.op(); err != nil {
+			t.Fatalf("op: %v", err)
+		}
+		if err := Pull(root); err != nil {
+			t.Fatalf("pull: %v", err)
+		}
+		ps, err := ListAvailable(root)
+		if err != nil {
+			t.Fatalf("list available: %v", err)
+		}
+		want := []AvailablePackage{{Name: "foo", Version: "1.0.0", Remote: test.want}}
+		if !reflect.DeepEqual(ps, want) {
+			t.Fatalf("available: got %v, want %v", ps, want)
+		}
+	}
+}
+
+func TestRemoteTemplates(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	defer func(o, a string) { OS, Arch = o, a }(OS, Arch)
+	for _, host := range []struct{ os, arch string }{
+		{"linux", "arm64"},
+		{"darwin", "amd64"},
+	} {
+		srv := filepath.Join(root, "srv", host.os, host.arch, "stable")
+		if err := os.MkdirAll(srv, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		av := fmt.Sprintf(`{"foo": {"1.0.0": {"name": "foo", "version": "1.0.0", "description": %q}}}`, host.os+"/"+host.arch)
+		if err := ioutil.WriteFile(filepath.Join(srv, "available.json"), []byte(av), 0644); err != nil {
+			t.Fatalf("writing available: %v", err)
+		}
+	}
+
+	if err := AddRemotes(root, []string{filepath.Join(root, "srv", "{os}", "{hostname}", "stable")}); err == nil {
+		t.Fatalf("added remote with unknown placeholder")
+	}
+	tmpl := filepath.Join(root, "srv", "{os}", "{arch}", "stable")
+	if err := AddRemotes(root, []string{tmpl}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	rs, err := ListRemotes(root)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	want := []RemoteEntry{{Name: "stable", Priority: 10, Enabled: true, URL: "file://" + filepath.ToSlash(tmpl), Mirrors: []string{}}}
+	if !reflect.DeepEqual(rs, want) {
+		t.Fatalf("list: got %v, want %v", rs, want)
+	}
+
+	for _, host := range []struct{ os, arch string }{
+		{"linux", "arm64"},
+		{"darwin", "amd64"},
+	} {
+		OS, Arch = host.os, host.arch
+		if err := Pull(root); err != nil {
+			t.Fatalf("pull: %v", err)
+		}
+		a, err := LoadAvailable(root)
+		if err != nil {
+			t.Fatalf("load available: %v", err)
+		}
+		m, err := a.Get("foo", "")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if got, want := m.Description, host.os+"/"+host.arch; got != want {
+			t.Fatalf("description: got %v, want %v", got, want)
+		}
+		us, err := Mirrors(root, m.Remote)
+		if err != nil {
+			t.Fatalf("mirrors: %v", err)
+		}
+		if got, want := us[0].Path, filepath.ToSlash(filepath.Join(root, "srv", host.os, host.arch, "stable")); got != want {
+			t.Fatalf("package url: got %v, want %v", got, want)
+		}
+	}
+
+	if err := RemoveRemotes(root, []string{tmpl}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
 }
@@ -0,0 +1,104 @@
+// Package pmtest holds fixtures shared by the tests of pm's packages.
+package pmtest
+
+import (
+	"archive/tar"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mcquay.me/pm/keyring"
+)
+
+// Passphrase protects the secret keys tests create.
+const Passphrase = "p"
+
+// rootTBZ is a root.tar.bz2 containing bin/hi.
+const rootTBZ = `QlpoOTFBWSZTWT119C4AAJd7hMqRAEBoAP+AABR6YZ4AAACACCAAlISimmmjSaDRiZ5SM1AkqaNA
+AAADTR7jqqvCIakBPIhJFw0mCQoiYNCsgEgYK/eCVnBUcef3OSkpiHsUAZ5gMbKLEgWdxtYpYKSn
+kOSFIzLhQ1G8Ny1IYU2KI6VODhQsHE7xoUjyMSD8XckU4UJA9dfQuA==`
+
+// Dir creates a temporary directory, and returns it with a func that
+// removes it.
+func Dir(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "pm-tests-")
+	if err != nil {
+		t.Fatalf("tmpdir: %v", err)
+	}
+	return root, func() {
+		if err := os.RemoveAll(root); err != nil {
+			t.Fatalf("cleanup: %v", err)
+		}
+	}
+}
+
+// meta returns the meta.yaml of a test package.
+func meta(name, version string) []byte {
+	return []byte("name: " + name + "\nversion: " + version + "\ndescription: test\n")
+}
+
+// FakePkg writes a .pkg containing only meta.yaml to dir.
+func FakePkg(t *testing.T, dir, name, version string) string {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	pn := filepath.Join(dir, name+"-"+version+".pkg")
+	f, err := os.Create(pn)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	m := meta(name, version)
+	tw := tar.NewWriter(f)
+	if err := tw.WriteHeader(&tar.Header{Name: "meta.yaml", Mode: 0644, Size: int64(len(m))}); err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	if _, err := tw.Write(m); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return pn
+}
+
+// SignedPkg builds a .pkg in dir that installs bin/hi, signed by the key for
+// id in the keyring below root. create is pkg.Create, which pmtest cannot
+// import without a cycle in pkg's own tests.
+func SignedPkg(t *testing.T, root, dir, id, name, version string, create func(keyring.Signer, string) error) string {
+	src := filepath.Join(dir, name)
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	tbz, err := base64.StdEncoding.DecodeString(rootTBZ)
+	if err != nil {
+		t.Fatalf("decoding root.tar.bz2: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "root.tar.bz2"), tbz, 0644); err != nil {
+		t.Fatalf("writing root.tar.bz2: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "meta.yaml"), meta(name, version), 0644); err != nil {
+		t.Fatalf("writing meta.yaml: %v", err)
+	}
+	if err := create(Signer(t, root, id), src); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	return filepath.Join(dir, name+"-"+version+".pkg")
+}
+
+// Signer returns the signer for id in the keyring below root, whose secret
+// key is protected by Passphrase.
+func Signer(t *testing.T, root, id string) keyring.Signer {
+	s, err := keyring.FindSigner(root, id, func() ([]byte, error) { return []byte(Passphrase), nil })
+	if err != nil {
+		t.Fatalf("find signer: %v", err)
+	}
+	return s
+}
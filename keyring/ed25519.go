@@ -0,0 +1,534 @@
+package keyring
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+
+	"mcquay.me/fs"
+)
+
+// The ed25519 signatures and public keys written by pm follow the minisign
+// (https://jedisct1.github.io/minisign) text formats, so they can be
+// produced and checked with either tool.
+const (
+	untrustedPrefix = "untrusted comment: "
+	trustedPrefix   = "trusted comment: "
+)
+
+// edSecretComment starts the untrusted comment of an exported ed25519 secret
+// key, which, unlike public keys and signatures, is not in a minisign format.
+const edSecretComment = "pm encrypted ed25519 secret key "
+
+var edAlg = []byte("Ed")
+
+var errUnknownEdKey = errors.New("signature made by unknown ed25519 key")
+
+// edKey is an ed25519 public key.
+type edKey struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Email   string            `json:"email"`
+	Created time.Time         `json:"created"`
+	Public  ed25519.PublicKey `json:"public"`
+}
+
+// Fingerprint returns the full, upper-case hex encoding of the raw public
+// key.
+func (k edKey) Fingerprint() string {
+	return fmt.Sprintf("%X", []byte(k.Public))
+}
+
+// keyNum returns the 8 byte key number embedded in minisign signatures.
+func (k edKey) keyNum() ([]byte, error) {
+	n, err := strconv.ParseUint(k.ID, 16, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing key id %q", k.ID)
+	}
+	r := make([]byte, 8)
+	binary.LittleEndian.PutUint64(r, n)
+	return r, nil
+}
+
+// key describes k as a member of the keyring kind.
+func (k edKey) key(kind string) Key {
+	return Key{
+		Keyring:      kind,
+		Fingerprint:  k.Fingerprint(),
+		Algorithm:    "ed25519",
+		Created:      k.Created,
+		Capabilities: "S",
+		Identities:   []string{k.identity()},
+	}
+}
+
+func (k edKey) identity() string {
+	if k.Email == "" {
+		return k.Name
+	}
+	return fmt.Sprintf("%v <%v>", k.Name, k.Email)
+}
+
+// edSecret is a passphrase protected ed25519 secret key.
+//
+// The ed25519 seed is sealed with NaCl secretbox using a key derived from the
+// passphrase with scrypt.
+type edSecret struct {
+	edKey
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Seed  []byte `json:"seed"`
+}
+
+// unlock returns the decrypted private key of s.
+func (s edSecret) unlock(passphrase []byte) (ed25519.PrivateKey, error) {
+	key, err := boxKey(passphrase, s.Salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving key")
+	}
+	if len(s.Nonce) != 24 {
+		return nil, errors.New("malformed nonce")
+	}
+	var nonce [24]byte
+	copy(nonce[:], s.Nonce)
+	seed, ok := secretbox.Open(nil, s.Seed, &nonce, key)
+	if !ok || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("decrypting ed25519 key; bad passphrase?")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func boxKey(passphrase, salt []byte) (*[32]byte, error) {
+	k, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	var r [32]byte
+	copy(r[:], k)
+	return &r, nil
+}
+
+// seal returns an edSecret for k with priv encrypted using passphrase.
+func seal(k edKey, priv ed25519.PrivateKey, passphrase []byte) (edSecret, error) {
+	s := edSecret{
+		edKey: k,
+		Salt:  make([]byte, 32),
+		Nonce: make([]byte, 24),
+	}
+	if _, err := io.ReadFull(rand.Reader, s.Salt); err != nil {
+		return s, errors.Wrap(err, "generating salt")
+	}
+	if _, err := io.ReadFull(rand.Reader, s.Nonce); err != nil {
+		return s, errors.Wrap(err, "generating nonce")
+	}
+	key, err := boxKey(passphrase, s.Salt)
+	if err != nil {
+		return s, errors.Wrap(err, "deriving key")
+	}
+	var nonce [24]byte
+	copy(nonce[:], s.Nonce)
+	s.Seed = secretbox.Seal(nil, priv.Seed(), &nonce, key)
+	return s, nil
+}
+
+// NewEd25519KeyPair creates and adds a new ed25519 keypair to an existing
+// keyring, encrypting the secret key using passphrase.
+func NewEd25519KeyPair(root, name, email string, passphrase []byte) error {
+	if name == "" {
+		return errors.New("name cannot be empty")
+	}
+	if email == "" {
+		return errors.New("email cannot be empty")
+	}
+	if strings.ContainsAny(email, "()<>\x00") {
+		return fmt.Errorf("email %q contains invalid chars", email)
+	}
+	if len(passphrase) == 0 {
+		return errors.New("passphrase cannot be empty")
+	}
+	pubs, secs, err := loadEd(root)
+	if err != nil {
+		return errors.Wrap(err, "loading ed25519 keys")
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "generating key")
+	}
+	num := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, num); err != nil {
+		return errors.Wrap(err, "generating key id")
+	}
+	k := edKey{
+		ID:      fmt.Sprintf("%016X", binary.LittleEndian.Uint64(num)),
+		Name:    name,
+		Email:   email,
+		Created: time.Now().UTC(),
+		Public:  pub,
+	}
+
+	s, err := seal(k, priv, passphrase)
+	if err != nil {
+		return errors.Wrap(err, "sealing secret key")
+	}
+
+	if err := saveEd(root, append(pubs, k), append(secs, s)); err != nil {
+		return errors.Wrap(err, "saving ed25519 keys")
+	}
+	return nil
+}
+
+// changeEdPassphrase re-encrypts the secret key for k, currently protected by
+// old, using new.
+func changeEdPassphrase(root string, k edKey, old, new []byte) error {
+	pubs, secs, err := loadEd(root)
+	if err != nil {
+		return errors.Wrap(err, "loading ed25519 keys")
+	}
+	for i, s := range secs {
+		if s.Fingerprint() != k.Fingerprint() {
+			continue
+		}
+		priv, err := s.unlock(old)
+		if err != nil {
+			return errors.Wrap(err, "unlocking key")
+		}
+		secs[i], err = seal(s.edKey, priv, new)
+		if err != nil {
+			return errors.Wrap(err, "sealing secret key")
+		}
+		return saveEd(root, pubs, secs)
+	}
+	return fmt.Errorf("key %q not found", k.Fingerprint())
+}
+
+// edSigner signs with an unlocked ed25519 key.
+type edSigner struct {
+	k    edKey
+	priv ed25519.PrivateKey
+}
+
+func (s *edSigner) Fingerprint() string { return s.k.Fingerprint() }
+func (s *edSigner) KeyID() string       { return s.k.ID }
+
+// Sign writes a minisign-compatible signature for in to sig.
+func (s *edSigner) Sign(in io.Reader, sig io.Writer) error {
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return errors.Wrap(err, "reading input")
+	}
+	num, err := s.k.keyNum()
+	if err != nil {
+		return err
+	}
+	sum := ed25519.Sign(s.priv, b)
+	trusted := fmt.Sprintf("timestamp:%d\tkey:%v", time.Now().Unix(), s.k.Fingerprint())
+	global := ed25519.Sign(s.priv, append(append([]byte{}, sum...), trusted...))
+
+	raw := append(append(append([]byte{}, edAlg...), num...), sum...)
+	fmt.Fprintf(sig, "%vsignature from pm secret key %v\n", untrustedPrefix, s.k.ID)
+	fmt.Fprintf(sig, "%v\n", base64.StdEncoding.EncodeToString(raw))
+	fmt.Fprintf(sig, "%v%v\n", trustedPrefix, trusted)
+	fmt.Fprintf(sig, "%v\n", base64.StdEncoding.EncodeToString(global))
+	return nil
+}
+
+// edSig is a parsed minisign signature.
+type edSig struct {
+	id      string
+	sig     []byte
+	trusted []byte
+	global  []byte
+}
+
+func parseEdSig(b []byte) (edSig, error) {
+	r := edSig{}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 4 {
+		return r, errors.Errorf("ed25519 signature has %d lines, want 4", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], untrustedPrefix) {
+		return r, errors.New("missing untrusted comment")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return r, errors.Wrap(err, "decoding signature")
+	}
+	if len(raw) != 2+8+ed25519.SignatureSize || !bytes.Equal(raw[:2], edAlg) {
+		return r, errors.New("unsupported signature algorithm")
+	}
+	r.id = fmt.Sprintf("%016X", binary.LittleEndian.Uint64(raw[2:10]))
+	r.sig = raw[10:]
+	if !strings.HasPrefix(lines[2], trustedPrefix) {
+		return r, errors.New("missing trusted comment")
+	}
+	r.trusted = []byte(strings.TrimPrefix(lines[2], trustedPrefix))
+	r.global, err = base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return r, errors.Wrap(err, "decoding global signature")
+	}
+	return r, nil
+}
+
+// verifyEd checks the minisign signature b of file against keys, returning
+// the key that made it.
+func verifyEd(keys []edKey, file, b []byte) (edKey, error) {
+	s, err := parseEdSig(b)
+	if err != nil {
+		return edKey{}, errors.Wrap(err, "parsing ed25519 signature")
+	}
+	for _, k := range keys {
+		if k.ID != s.id {
+			continue
+		}
+		if !ed25519.Verify(k.Public, file, s.sig) {
+			return k, errors.Errorf("invalid signature by %v", k.Fingerprint())
+		}
+		if !ed25519.Verify(k.Public, append(append([]byte{}, s.sig...), s.trusted...), s.global) {
+			return k, errors.Errorf("invalid trusted comment signature by %v", k.Fingerprint())
+		}
+		return k, nil
+	}
+	return edKey{}, errUnknownEdKey
+}
+
+// findEd searches keys for id, which may be an email address, a key id, or a
+// full fingerprint, returning all matches.
+func findEd(keys []edKey, id string) []edKey {
+	r := []edKey{}
+	hex := normalizeID(id)
+	for _, k := range keys {
+		switch {
+		case strings.Contains(id, "@"):
+			if k.Email == id {
+				r = append(r, k)
+			}
+		case len(hex) == 64:
+			if k.Fingerprint() == hex {
+				r = append(r, k)
+			}
+		case len(hex) == 16:
+			if k.ID == hex {
+				r = append(r, k)
+			}
+		}
+	}
+	return r
+}
+
+// exportEd writes k in the minisign public key format to w.
+func exportEd(w io.Writer, k edKey) error {
+	num, err := k.keyNum()
+	if err != nil {
+		return err
+	}
+	raw := append(append(append([]byte{}, edAlg...), num...), k.Public...)
+	fmt.Fprintf(w, "%vminisign public key %v\n", untrustedPrefix, k.ID)
+	fmt.Fprintf(w, "%v\n", base64.StdEncoding.EncodeToString(raw))
+	return nil
+}
+
+// importEd parses a minisign public key from b and adds it to the keyring,
+// returning how many keys were new.
+func importEd(root string, b []byte) (int, error) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	lines := []string{}
+	for s.Scan() {
+		if l := strings.TrimSpace(s.Text()); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) != 2 {
+		return 0, errors.Errorf("ed25519 public key has %d lines, want 2", len(lines))
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return 0, errors.Wrap(err, "decoding public key")
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize || !bytes.Equal(raw[:2], edAlg) {
+		return 0, errors.New("unsupported public key algorithm")
+	}
+	k := edKey{
+		ID:      fmt.Sprintf("%016X", binary.LittleEndian.Uint64(raw[2:10])),
+		Name:    strings.TrimPrefix(lines[0], untrustedPrefix),
+		Created: time.Now().UTC(),
+		Public:  ed25519.PublicKey(raw[10:]),
+	}
+
+	pubs, secs, err := loadEd(root)
+	if err != nil {
+		return 0, errors.Wrap(err, "loading ed25519 keys")
+	}
+	for _, p := range pubs {
+		if p.Fingerprint() == k.Fingerprint() {
+			return 0, nil
+		}
+	}
+	if err := saveEd(root, append(pubs, k), secs); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// exportEdSecret writes s, still sealed, to w as an untrusted comment
+// followed by the base64 encoding of its json.
+func exportEdSecret(w io.Writer, s edSecret) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "encoding secret key")
+	}
+	fmt.Fprintf(w, "%v%v%v\n", untrustedPrefix, edSecretComment, s.ID)
+	fmt.Fprintf(w, "%v\n", base64.StdEncoding.EncodeToString(b))
+	return nil
+}
+
+// importEdSecret parses a secret key written by exportEdSecret from b and
+// adds it, and its public key, to the keyring, returning how many keys were
+// new.
+func importEdSecret(root string, b []byte) (int, error) {
+	lines := strings.Fields(string(b))
+	raw, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil {
+		return 0, errors.Wrap(err, "decoding secret key")
+	}
+	s := edSecret{}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, errors.Wrap(err, "parsing secret key")
+	}
+	if len(s.Public) != ed25519.PublicKeySize {
+		return 0, errors.New("malformed ed25519 public key")
+	}
+
+	pubs, secs, err := loadEd(root)
+	if err != nil {
+		return 0, errors.Wrap(err, "loading ed25519 keys")
+	}
+	for _, o := range secs {
+		if o.Fingerprint() == s.Fingerprint() {
+			return 0, nil
+		}
+	}
+	secs = append(secs, s)
+	known := false
+	for _, p := range pubs {
+		if p.Fingerprint() == s.Fingerprint() {
+			known = true
+		}
+	}
+	if !known {
+		pubs = append(pubs, s.edKey)
+	}
+	if err := saveEd(root, pubs, secs); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// removeEd removes the ed25519 public key k, unless it has a matching secret
+// key.
+func removeEd(root string, k edKey) error {
+	pubs, secs, err := loadEd(root)
+	if err != nil {
+		return errors.Wrap(err, "loading ed25519 keys")
+	}
+	for _, s := range secs {
+		if s.Fingerprint() == k.Fingerprint() {
+			return fmt.Errorf("skipping pubkey with matching privkey: %v", k.Fingerprint())
+		}
+	}
+	o := []edKey{}
+	for _, p := range pubs {
+		if p.Fingerprint() != k.Fingerprint() {
+			o = append(o, p)
+		}
+	}
+	return saveEd(root, o, secs)
+}
+
+func edDir(root string) string {
+	return filepath.Join(root, "var", "lib", "pm", "ed25519")
+}
+
+func edNames(root string) (string, string) {
+	return filepath.Join(edDir(root), "seckeys.json"), filepath.Join(edDir(root), "pubkeys.json")
+}
+
+func loadEd(root string) ([]edKey, []edSecret, error) {
+	pubs, secs := []edKey{}, []edSecret{}
+	sn, pn := edNames(root)
+	for _, f := range []struct {
+		name string
+		v    interface{}
+	}{
+		{sn, &secs},
+		{pn, &pubs},
+	} {
+		if !fs.Exists(f.name) {
+			continue
+		}
+		b, err := ioutil.ReadFile(f.name)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "reading %v", f.name)
+		}
+		if err := json.Unmarshal(b, f.v); err != nil {
+			return nil, nil, errors.Wrapf(err, "decoding %v", f.name)
+		}
+	}
+	return pubs, secs, nil
+}
+
+func saveEd(root string, pubs []edKey, secs []edSecret) error {
+	d := edDir(root)
+	if !fs.Exists(d) {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return errors.Wrap(err, "mk ed25519 dir")
+		}
+	}
+	sn, pn := edNames(root)
+	for _, f := range []struct {
+		name string
+		v    interface{}
+	}{
+		{sn, secs},
+		{pn, pubs},
+	} {
+		b, err := json.MarshalIndent(f.v, "", "\t")
+		if err != nil {
+			return errors.Wrapf(err, "encoding %v", f.name)
+		}
+		tf, err := ioutil.TempFile(d, "keys-")
+		if err != nil {
+			return errors.Wrap(err, "creating temporary key file")
+		}
+		if _, err := tf.Write(b); err != nil {
+			tf.Close()
+			os.Remove(tf.Name())
+			return errors.Wrapf(err, "writing %v", f.name)
+		}
+		if err := tf.Close(); err != nil {
+			os.Remove(tf.Name())
+			return errors.Wrapf(err, "closing %v", f.name)
+		}
+		if err := os.Rename(tf.Name(), f.name); err != nil {
+			os.Remove(tf.Name())
+			return errors.Wrapf(err, "replacing %v", f.name)
+		}
+	}
+	return nil
+}
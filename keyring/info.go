@@ -0,0 +1,156 @@
+package keyring
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Fingerprint returns the full, upper-case hex fingerprint of e's primary
+// key.
+func Fingerprint(e *openpgp.Entity) string {
+	return fmt.Sprintf("%X", e.PrimaryKey.Fingerprint[:])
+}
+
+// algorithm returns a short description of e's primary key algorithm and
+// size, e.g. rsa2048.
+func algorithm(e *openpgp.Entity) string {
+	var n string
+	switch e.PrimaryKey.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly, packet.PubKeyAlgoRSAEncryptOnly:
+		n = "rsa"
+	case packet.PubKeyAlgoDSA:
+		n = "dsa"
+	case packet.PubKeyAlgoElGamal:
+		n = "elgamal"
+	case packet.PubKeyAlgoECDSA:
+		n = "ecdsa"
+	case packet.PubKeyAlgoECDH:
+		n = "ecdh"
+	default:
+		return fmt.Sprintf("algo%d", e.PrimaryKey.PubKeyAlgo)
+	}
+	bits, err := e.PrimaryKey.BitLength()
+	if err != nil {
+		return n
+	}
+	return fmt.Sprintf("%s%d", n, bits)
+}
+
+// selfSig returns the self signature of e's primary identity.
+func selfSig(e *openpgp.Entity) *packet.Signature {
+	var r *packet.Signature
+	for _, id := range e.Identities {
+		if id.SelfSignature == nil {
+			continue
+		}
+		if id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId {
+			return id.SelfSignature
+		}
+		if r == nil {
+			r = id.SelfSignature
+		}
+	}
+	return r
+}
+
+// capabilities returns the usage flags of e and its subkeys in the usual
+// gpg notation: (S)ign, (C)ertify, and (E)ncrypt.
+func capabilities(e *openpgp.Entity) string {
+	var s, c, enc bool
+	if sig := selfSig(e); sig != nil && sig.FlagsValid {
+		s, c = sig.FlagSign, sig.FlagCertify
+		enc = sig.FlagEncryptCommunications || sig.FlagEncryptStorage
+	} else {
+		s = e.PrimaryKey.PubKeyAlgo.CanSign()
+		c = s
+	}
+	for _, sk := range e.Subkeys {
+		if sk.Sig == nil || !sk.Sig.FlagsValid {
+			continue
+		}
+		s = s || sk.Sig.FlagSign
+		enc = enc || sk.Sig.FlagEncryptCommunications || sk.Sig.FlagEncryptStorage
+	}
+	r := ""
+	if s {
+		r += "S"
+	}
+	if c {
+		r += "C"
+	}
+	if enc {
+		r += "E"
+	}
+	return r
+}
+
+// expires returns when e's primary key expires, or the zero time if it
+// never does.
+func expires(e *openpgp.Entity) time.Time {
+	sig := selfSig(e)
+	if sig == nil || sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return time.Time{}
+	}
+	return e.PrimaryKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+}
+
+// names returns the sorted identity names of e.
+func names(e *openpgp.Entity) []string {
+	ns := []string{}
+	for _, v := range e.Identities {
+		ns = append(ns, v.Name)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// Key describes a key in the keyring, as listed by ListKeys.
+type Key struct {
+	// Keyring is "sec" for secret keys and "pub" for public keys.
+	Keyring     string `json:"keyring"`
+	Fingerprint string `json:"fingerprint"`
+
+	// Algorithm is the key's algorithm and size, e.g. rsa2048 or ed25519.
+	Algorithm string    `json:"algorithm"`
+	Created   time.Time `json:"created"`
+
+	// Expires is nil if the key never expires.
+	Expires *time.Time `json:"expires"`
+
+	// Capabilities are in the usual gpg notation: (S)ign, (C)ertify, and
+	// (E)ncrypt.
+	Capabilities string   `json:"capabilities"`
+	Identities   []string `json:"identities"`
+
+	// Unprotected is set for secret keys not encrypted with a passphrase.
+	Unprotected bool `json:"unprotected,omitempty"`
+}
+
+// pgpKey describes the interesting bits of e.
+func pgpKey(kind string, e *openpgp.Entity) Key {
+	k := Key{
+		Keyring:      kind,
+		Fingerprint:  Fingerprint(e),
+		Algorithm:    algorithm(e),
+		Created:      e.PrimaryKey.CreationTime,
+		Capabilities: capabilities(e),
+		Identities:   names(e),
+	}
+	if t := expires(e); !t.IsZero() {
+		k.Expires = &t
+	}
+	return k
+}
+
+// normalizeID strips common decorations from a hex key id or fingerprint,
+// e.g. spaces and a leading 0x, and upper-cases it.
+func normalizeID(id string) string {
+	id = strings.Replace(id, " ", "", -1)
+	id = strings.TrimPrefix(strings.TrimPrefix(id, "0x"), "0X")
+	return strings.ToUpper(id)
+}
@@ -1,8 +1,10 @@
 package keyring
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,7 +17,10 @@ import (
 )
 
 // NewKeyPair creates and adds a new OpenPGP keypair to an existing keyring.
-func NewKeyPair(root, name, email string) error {
+//
+// The secret key material is encrypted using passphrase before it is written
+// to disk.
+func NewKeyPair(root, name, email string, passphrase []byte) error {
 	if name == "" {
 		return errors.New("name cannot be empty")
 	}
@@ -25,43 +30,40 @@ func NewKeyPair(root, name, email string) error {
 	if strings.ContainsAny(email, "()<>\x00") {
 		return fmt.Errorf("email %q contains invalid chars", email)
 	}
+	if len(passphrase) == 0 {
+		return errors.New("passphrase cannot be empty")
+	}
 	if err := ensureDir(root); err != nil {
 		return errors.Wrap(err, "can't find or create pgp dir")
 	}
 	srn, prn := getNames(root)
-	secs, pubs, err := getELs(srn, prn)
+	_, pubs, err := getELs(srn, prn)
 	if err != nil {
 		return errors.Wrap(err, "getting existing keyrings")
 	}
 
 	fresh, err := openpgp.NewEntity(name, "pm", email, nil)
 	if err != nil {
-		errors.Wrap(err, "new entity")
+		return errors.Wrap(err, "new entity")
 	}
 
-	pr, err := os.Create(prn)
-	if err != nil {
-		return errors.Wrap(err, "opening pubring")
-	}
-	sr, err := os.Create(srn)
+	// existing secret keys may be encrypted, which x/crypto/openpgp cannot
+	// serialize, so we append to the secring rather than rewriting it.
+	sr, err := os.OpenFile(srn, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
 		return errors.Wrap(err, "opening secring")
 	}
-
-	for _, e := range secs {
-		if err := e.SerializePrivate(sr, nil); err != nil {
-			return errors.Wrapf(err, "serializing old private key: %v", e.PrimaryKey.KeyIdString())
-		}
-	}
-	// order is critical here; if we don't serialize the private key of fresh
-	// first, the later steps fail.
-	if err := fresh.SerializePrivate(sr, nil); err != nil {
+	if err := serializeEncrypted(sr, fresh, passphrase); err != nil {
 		return errors.Wrapf(err, "serializing fresh private %v", fresh.PrimaryKey.KeyIdString())
 	}
 	if err := sr.Close(); err != nil {
 		return errors.Wrap(err, "closing secring")
 	}
 
+	pr, err := os.Create(prn)
+	if err != nil {
+		return errors.Wrap(err, "opening pubring")
+	}
 	for _, e := range pubs {
 		if err := e.Serialize(pr); err != nil {
 			return errors.Wrapf(err, "serializing %v", e.PrimaryKey.KeyIdString())
@@ -76,35 +78,41 @@ func NewKeyPair(root, name, email string) error {
 	return nil
 }
 
-// ListKeys prints keyring information to w.
-func ListKeys(root string, w io.Writer) error {
+// ListKeys returns the secret and public keys in the keyring.
+func ListKeys(root string) ([]Key, error) {
 	if err := ensureDir(root); err != nil {
-		return errors.Wrap(err, "can't find or create pgp dir")
+		return nil, errors.Wrap(err, "can't find or create pgp dir")
 	}
 	srn, prn := getNames(root)
 	secs, pubs, err := getELs(srn, prn)
 	if err != nil {
-		return errors.Wrap(err, "getting existing keyrings")
+		return nil, errors.Wrap(err, "getting existing keyrings")
 	}
+	r := []Key{}
 	for _, s := range secs {
-		names := []string{}
-		for _, v := range s.Identities {
-			names = append(names, v.Name)
-		}
-		fmt.Fprintf(w, "sec: %+v:\t%v\n", s.PrimaryKey.KeyIdShortString(), strings.Join(names, ","))
+		k := pgpKey("sec", s)
+		k.Unprotected = !Encrypted(s)
+		r = append(r, k)
 	}
 	for _, p := range pubs {
-		names := []string{}
-		for _, v := range p.Identities {
-			names = append(names, v.Name)
-		}
-		fmt.Fprintf(w, "pub: %+v:\t%v\n", p.PrimaryKey.KeyIdShortString(), strings.Join(names, ","))
+		r = append(r, pgpKey("pub", p))
 	}
-	return nil
+
+	epubs, esecs, err := loadEd(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading ed25519 keys")
+	}
+	for _, s := range esecs {
+		r = append(r, s.key("sec"))
+	}
+	for _, p := range epubs {
+		r = append(r, p.key("pub"))
+	}
+	return r, nil
 }
 
-// Export prints pubkey information associated with email to w.
-func Export(root string, w io.Writer, email string) error {
+// Export prints pubkey information associated with id to w.
+func Export(root string, w io.Writer, id string) error {
 	if err := ensureDir(root); err != nil {
 		return errors.Wrap(err, "can't find or create pgp dir")
 	}
@@ -114,7 +122,15 @@ func Export(root string, w io.Writer, email string) error {
 		return errors.Wrap(err, "getting existing keyrings")
 	}
 
-	e, err := findKey(pubs, email)
+	ek, err := edMatch(root, pubs, id)
+	if err != nil {
+		return errors.Wrap(err, "find key")
+	}
+	if ek != nil {
+		return exportEd(w, *ek)
+	}
+
+	e, err := findKey(pubs, id)
 	if err != nil {
 		return errors.Wrap(err, "find key")
 	}
@@ -134,57 +150,201 @@ func Export(root string, w io.Writer, email string) error {
 	return nil
 }
 
-// Import parses public key information from w and adds it to the public
-// keyring.
+// ExportAll writes all public keys in the keyring to w: the OpenPGP keys as
+// a single armored keyring, followed by any ed25519 keys.
+func ExportAll(root string, w io.Writer) error {
+	if err := ensureDir(root); err != nil {
+		return errors.Wrap(err, "can't find or create pgp dir")
+	}
+	srn, prn := getNames(root)
+	_, pubs, err := getELs(srn, prn)
+	if err != nil {
+		return errors.Wrap(err, "getting existing keyrings")
+	}
+	eds, _, err := loadEd(root)
+	if err != nil {
+		return errors.Wrap(err, "loading ed25519 keys")
+	}
+
+	if len(pubs) > 0 {
+		aw, err := armor.Encode(w, openpgp.PublicKeyType, nil)
+		if err != nil {
+			return errors.Wrap(err, "creating armor encoder")
+		}
+		for _, e := range pubs {
+			if err := e.Serialize(aw); err != nil {
+				return errors.Wrapf(err, "serializing %v", e.PrimaryKey.KeyIdString())
+			}
+		}
+		if err := aw.Close(); err != nil {
+			return errors.Wrap(err, "closing armor encoder")
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	for _, k := range eds {
+		if err := exportEd(w, k); err != nil {
+			return errors.Wrapf(err, "exporting %v", k.Fingerprint())
+		}
+	}
+	return nil
+}
+
+// Import parses armored key information from w and adds it to the keyrings.
+//
+// Public keys are added to the public keyring. Secret keys, as written by
+// ExportSecret, are added to the secret keyring and their public parts to the
+// public keyring. ed25519 public keys in the minisign format are also
+// accepted. w may contain any number of concatenated keys, e.g. as written by
+// ExportAll.
 func Import(root string, w io.Reader) error {
-	el, err := openpgp.ReadArmoredKeyRing(w)
+	b, err := ioutil.ReadAll(w)
+	if err != nil {
+		return errors.Wrap(err, "reading key material")
+	}
+	blocks, err := splitKeys(b)
+	if err != nil {
+		return errors.Wrap(err, "parsing key material")
+	}
+	if len(blocks) == 0 {
+		return errors.New("no key material found")
+	}
+
+	added := 0
+	for _, kb := range blocks {
+		var n int
+		switch {
+		case bytes.HasPrefix(kb, []byte(untrustedPrefix+edSecretComment)):
+			n, err = importEdSecret(root, kb)
+		case bytes.HasPrefix(kb, []byte(untrustedPrefix)):
+			n, err = importEd(root, kb)
+		default:
+			n, err = importArmored(root, kb)
+		}
+		if err != nil {
+			return err
+		}
+		added += n
+	}
+	if added == 0 {
+		return errors.New("no new key material found")
+	}
+	return nil
+}
+
+// splitKeys splits b into its constituent armored OpenPGP and minisign
+// ed25519 keys.
+func splitKeys(b []byte) ([][]byte, error) {
+	r := [][]byte{}
+	lines := strings.SplitAfter(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		l := strings.TrimSpace(lines[i])
+		switch {
+		case l == "":
+		case strings.HasPrefix(l, "-----BEGIN PGP "):
+			j := i
+			for j < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[j]), "-----END PGP ") {
+				j++
+			}
+			if j == len(lines) {
+				return nil, errors.New("unterminated armored key")
+			}
+			r = append(r, []byte(strings.Join(lines[i:j+1], "")))
+			i = j
+		case strings.HasPrefix(l, untrustedPrefix):
+			if i+2 > len(lines) {
+				return nil, errors.New("truncated ed25519 key")
+			}
+			r = append(r, []byte(strings.Join(lines[i:i+2], "")))
+			i++
+		default:
+			return nil, errors.Errorf("unexpected content in key material: %q", l)
+		}
+	}
+	return r, nil
+}
+
+// importArmored adds the keys in the armored block b to the keyrings,
+// returning how many were new.
+func importArmored(root string, b []byte) (int, error) {
+	block, err := armor.Decode(bytes.NewReader(b))
 	if err != nil {
-		return errors.Wrap(err, "reading keyring")
+		return 0, errors.Wrap(err, "decoding armor")
 	}
 
 	if err := ensureDir(root); err != nil {
-		return errors.Wrap(err, "can't find or create pgp dir")
+		return 0, errors.Wrap(err, "can't find or create pgp dir")
 	}
 	srn, prn := getNames(root)
 	_, pubs, err := getELs(srn, prn)
 	if err != nil {
-		return errors.Wrap(err, "getting existing keyrings")
+		return 0, errors.Wrap(err, "getting existing keyrings")
+	}
+
+	var el openpgp.EntityList
+	added := 0
+	switch block.Type {
+	case openpgp.PublicKeyType:
+		el, err = openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return 0, errors.Wrap(err, "reading keyring")
+		}
+	case openpgp.PrivateKeyType:
+		b, err := ioutil.ReadAll(block.Body)
+		if err != nil {
+			return 0, errors.Wrap(err, "reading secret keys")
+		}
+		ss, err := parseSecrets(b)
+		if err != nil {
+			return 0, errors.Wrap(err, "parsing secret keys")
+		}
+		added, err = addSecrets(srn, ss)
+		if err != nil {
+			return 0, errors.Wrap(err, "adding secret keys")
+		}
+		for _, s := range ss {
+			el = append(el, s.e)
+		}
+	default:
+		return 0, errors.Errorf("unexpected armor type %q", block.Type)
 	}
 
 	foreign := openpgp.EntityList{}
-	exist := map[uint64]bool{}
+	exist := map[string]bool{}
 	for _, p := range pubs {
-		exist[p.PrimaryKey.KeyId] = true
+		exist[Fingerprint(p)] = true
 	}
 
 	for _, e := range el {
-		if _, ok := exist[e.PrimaryKey.KeyId]; !ok {
+		if _, ok := exist[Fingerprint(e)]; !ok {
 			foreign = append(foreign, e)
 		}
 	}
 	if len(foreign) < 1 {
-		return errors.New("no new key material found")
+		return added, nil
 	}
 
 	pubs = append(pubs, foreign...)
 
 	pr, err := os.Create(prn)
 	if err != nil {
-		return errors.Wrap(err, "opening pubring")
+		return 0, errors.Wrap(err, "opening pubring")
 	}
 	for _, e := range pubs {
 		if err := e.Serialize(pr); err != nil {
-			return errors.Wrapf(err, "serializing %v", e.PrimaryKey.KeyIdString())
+			return 0, errors.Wrapf(err, "serializing %v", e.PrimaryKey.KeyIdString())
 		}
 	}
 	if err := pr.Close(); err != nil {
-		return errors.Wrap(err, "closing pubring")
+		return 0, errors.Wrap(err, "closing pubring")
 	}
-	return nil
+	return added + len(foreign), nil
 }
 
 // Sign takes an id and a reader and writes the signature for that id to sig.
 func Sign(key *openpgp.Entity, in io.Reader, sig io.Writer) error {
+	if Encrypted(key) {
+		return errors.New("secret key is encrypted; decrypt it first")
+	}
 	if err := openpgp.ArmoredDetachSign(sig, key, in, nil); err != nil {
 		return errors.Wrap(err, "armored detach sign")
 	}
@@ -193,19 +353,11 @@ func Sign(key *openpgp.Entity, in io.Reader, sig io.Writer) error {
 }
 
 // Verify verifies a file's deatched signature.
+//
+// A valid signature from any key in the public keyring is sufficient; see
+// VerifyPolicy for requiring more.
 func Verify(root string, file, sig io.Reader) error {
-	if err := ensureDir(root); err != nil {
-		return errors.Wrap(err, "can't find or create pgp dir")
-	}
-	srn, prn := getNames(root)
-	_, pubs, err := getELs(srn, prn)
-	if err != nil {
-		return errors.Wrap(err, "getting existing keyrings")
-	}
-	if _, err = openpgp.CheckArmoredDetachedSignature(pubs, file, sig); err != nil {
-		return errors.Wrap(err, "check sig")
-	}
-	return nil
+	return VerifyPolicy(root, file, sig, Policy{Threshold: 1})
 }
 
 // Remove removes public key information for a given id.
@@ -221,6 +373,13 @@ func Remove(root string, id string) error {
 	if err != nil {
 		return errors.Wrap(err, "getting existing keyrings")
 	}
+	ek, err := edMatch(root, pubs, id)
+	if err != nil {
+		return errors.Wrapf(err, "finding key %q", id)
+	}
+	if ek != nil {
+		return removeEd(root, *ek)
+	}
 	victim, err := findKey(pubs, id)
 	if err != nil {
 		return errors.Wrapf(err, "finding key %q", id)
@@ -232,11 +391,11 @@ func Remove(root string, id string) error {
 	}
 	var rerr error
 	for _, p := range pubs {
-		if victim.PrimaryKey.KeyId == p.PrimaryKey.KeyId {
-			if len(secs.KeysById(victim.PrimaryKey.KeyId)) == 0 {
+		if victim.PrimaryKey.Fingerprint == p.PrimaryKey.Fingerprint {
+			if !hasFingerprint(secs, Fingerprint(victim)) {
 				continue
 			}
-			rerr = fmt.Errorf("skipping pubkey with matching privkey: %v", p.PrimaryKey.KeyIdShortString())
+			rerr = fmt.Errorf("skipping pubkey with matching privkey: %v", Fingerprint(p))
 		}
 
 		if err := p.Serialize(pr); err != nil {
@@ -250,6 +409,36 @@ func Remove(root string, id string) error {
 	return rerr
 }
 
+// edMatch returns the ed25519 public key matching id, or nil if there is
+// none. It is an error for id to match both an ed25519 key and a key in pubs.
+func edMatch(root string, pubs openpgp.EntityList, id string) (*edKey, error) {
+	eds, _, err := loadEd(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading ed25519 keys")
+	}
+	ks := findEd(eds, id)
+	if len(ks) == 0 {
+		return nil, nil
+	}
+	if len(ks) > 1 {
+		return nil, errors.New("too many keys matched; try searching by full fingerprint?")
+	}
+	if _, err := findKey(pubs, id); err == nil {
+		return nil, fmt.Errorf("%q matches both OpenPGP and ed25519 keys; try searching by full fingerprint?", id)
+	}
+	return &ks[0], nil
+}
+
+// hasFingerprint reports if an entity with fingerprint fp is in el.
+func hasFingerprint(el openpgp.EntityList, fp string) bool {
+	for _, e := range el {
+		if Fingerprint(e) == fp {
+			return true
+		}
+	}
+	return false
+}
+
 func pGPDir(root string) string {
 	return filepath.Join(root, "var", "lib", "pm", "pgp")
 }
@@ -302,10 +491,11 @@ func getELs(secring, pubring string) (openpgp.EntityList, openpgp.EntityList, er
 	return sr, pr, nil
 }
 
+// findKey searches el for id, which may be an email address, a short or long
+// key id, or a full fingerprint.
 func findKey(el openpgp.EntityList, id string) (*openpgp.Entity, error) {
-	var e *openpgp.Entity
+	es := openpgp.EntityList{}
 	if strings.Contains(id, "@") {
-		es := openpgp.EntityList{}
 		for _, p := range el {
 			for _, v := range p.Identities {
 				if id == v.UserId.Email {
@@ -313,23 +503,38 @@ func findKey(el openpgp.EntityList, id string) (*openpgp.Entity, error) {
 				}
 			}
 		}
-		if len(es) == 1 {
-			return es[0], nil
-		}
-		if len(es) > 1 {
-			return nil, errors.New("too many keys matched; try searching by key id?")
-		}
 	} else {
+		hex := normalizeID(id)
 		for _, p := range el {
-			if id == p.PrimaryKey.KeyIdShortString() {
-				return p, nil
+			var cand string
+			switch len(hex) {
+			case 40:
+				cand = Fingerprint(p)
+			case 16:
+				cand = p.PrimaryKey.KeyIdString()
+			case 8:
+				cand = p.PrimaryKey.KeyIdShortString()
+			default:
+				return nil, fmt.Errorf("%q is not an email, key id, or fingerprint", id)
+			}
+			if hex == cand {
+				es = append(es, p)
 			}
 		}
 	}
-	return e, fmt.Errorf("key %q not found", id)
+	if len(es) == 1 {
+		return es[0], nil
+	}
+	if len(es) > 1 {
+		return nil, errors.New("too many keys matched; try searching by full fingerprint?")
+	}
+	return nil, fmt.Errorf("key %q not found", id)
 }
 
 // FindSecretEntity searches for id in the secret keyring.
+//
+// The returned entity's key material may still be encrypted; see Encrypted
+// and Decrypt.
 func FindSecretEntity(root, id string) (*openpgp.Entity, error) {
 	if err := ensureDir(root); err != nil {
 		return nil, errors.Wrap(err, "can't find or create pgp dir")
@@ -0,0 +1,206 @@
+package keyring
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestFindKey(t *testing.T) {
+	a, err := openpgp.NewEntity("a", "pm", "a@example.com", nil)
+	if err != nil {
+		t.Fatalf("new entity: %v", err)
+	}
+	b, err := openpgp.NewEntity("b", "pm", "b@example.com", nil)
+	if err != nil {
+		t.Fatalf("new entity: %v", err)
+	}
+	el := openpgp.EntityList{a, b}
+
+	fp := Fingerprint(b)
+	spaced := ""
+	for i := 0; i < len(fp); i += 4 {
+		spaced += fp[i:i+4] + " "
+	}
+
+	tests := []struct {
+		label string
+		id    string
+		want  *openpgp.Entity
+	}{
+		{label: "email", id: "a@example.com", want: a},
+		{label: "short", id: a.PrimaryKey.KeyIdShortString(), want: a},
+		{label: "long", id: b.PrimaryKey.KeyIdString(), want: b},
+		{label: "fingerprint", id: fp, want: b},
+		{label: "lower fingerprint", id: strings.ToLower(fp), want: b},
+		{label: "0x long", id: "0x" + b.PrimaryKey.KeyIdString(), want: b},
+		{label: "spaced fingerprint", id: spaced, want: b},
+		{label: "missing email", id: "c@example.com"},
+		{label: "missing fingerprint", id: strings.Repeat("0", 40)},
+		{label: "bad length", id: "ABCDEF"},
+	}
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			got, err := findKey(el, test.id)
+			if test.want == nil {
+				if err == nil {
+					t.Fatalf("should not have found %q", test.id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("find key: %v", err)
+			}
+			if got != test.want {
+				t.Fatalf("got %v, want %v", Fingerprint(got), Fingerprint(test.want))
+			}
+		})
+	}
+}
+
+func TestVerifyPolicy(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	keys := []*openpgp.Entity{}
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if err := NewKeyPair(root, "x", email, []byte("pass")); err != nil {
+			t.Fatalf("new key pair: %v", err)
+		}
+		e, err := FindSecretEntity(root, email)
+		if err != nil {
+			t.Fatalf("find secret: %v", err)
+		}
+		if err := Decrypt(e, []byte("pass")); err != nil {
+			t.Fatalf("decrypt: %v", err)
+		}
+		keys = append(keys, e)
+	}
+
+	msg := "manifest"
+	sigs := &bytes.Buffer{}
+	for _, k := range keys[:2] {
+		if err := Sign(k, strings.NewReader(msg), sigs); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+	}
+
+	for i, k := range keys {
+		signed, err := Signed(&pgpSigner{k}, sigs.Bytes())
+		if err != nil {
+			t.Fatalf("signed: %v", err)
+		}
+		if got, want := signed, i < 2; got != want {
+			t.Fatalf("signed by key %d: got %v, want %v", i, got, want)
+		}
+	}
+
+	all := []string{Fingerprint(keys[0]), Fingerprint(keys[1]), Fingerprint(keys[2])}
+	tests := []struct {
+		label     string
+		threshold int
+		keys      []string
+		msg       string
+		ok        bool
+	}{
+		{label: "any one", threshold: 1, msg: msg, ok: true},
+		{label: "any two", threshold: 2, msg: msg, ok: true},
+		{label: "any three", threshold: 3, msg: msg},
+		{label: "2 of 3", threshold: 2, keys: all, msg: msg, ok: true},
+		{label: "2 of 1 signed", threshold: 2, keys: all[1:], msg: msg},
+		{label: "only unsigned", threshold: 1, keys: all[2:], msg: msg},
+		{label: "tampered", threshold: 1, msg: "tampered"},
+	}
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			p, err := NewPolicy(test.threshold, test.keys)
+			if err != nil {
+				t.Fatalf("new policy: %v", err)
+			}
+			err = VerifyPolicy(root, strings.NewReader(test.msg), bytes.NewReader(sigs.Bytes()), p)
+			if got, want := err == nil, test.ok; got != want {
+				t.Fatalf("verify: got %v, want ok = %v", err, want)
+			}
+		})
+	}
+}
+
+func TestEd25519(t *testing.T) {
+	root, done := dirMe(t)
+	defer done()
+
+	if err := NewEd25519KeyPair(root, "c", "c@example.com", []byte("p")); err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	pass := func() ([]byte, error) { return []byte("p"), nil }
+	s, err := FindSigner(root, "c@example.com", pass)
+	if err != nil {
+		t.Fatalf("find signer: %v", err)
+	}
+	if got, want := len(s.Fingerprint()), 64; got != want {
+		t.Fatalf("fingerprint length: got %v, want %v", got, want)
+	}
+
+	msg := []byte("hello")
+	sig := &bytes.Buffer{}
+	if err := s.Sign(bytes.NewReader(msg), sig); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if ok, err := Signed(s, sig.Bytes()); err != nil || !ok {
+		t.Fatalf("signed: got %v, %v, want true", ok, err)
+	}
+
+	p := Policy{Threshold: 1, Keys: []string{s.Fingerprint()}}
+	if err := VerifyPolicy(root, bytes.NewReader(msg), bytes.NewReader(sig.Bytes()), p); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := VerifyPolicy(root, bytes.NewReader([]byte("tampered")), bytes.NewReader(sig.Bytes()), p); err == nil {
+		t.Fatalf("tampered message verified")
+	}
+
+	if _, err := FindSigner(root, "c@example.com", func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+		t.Fatalf("unlocked with wrong passphrase")
+	}
+}
+
+func TestExportAll(t *testing.T) {
+	src, sdone := dirMe(t)
+	defer sdone()
+	dst, ddone := dirMe(t)
+	defer ddone()
+
+	if err := NewKeyPair(src, "a", "a@example.com", []byte("p")); err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	if err := NewEd25519KeyPair(src, "b", "b@example.com", []byte("p")); err != nil {
+		t.Fatalf("new ed25519 key: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ExportAll(src, buf); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if err := Import(dst, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if err := Import(dst, bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatalf("re-import should find no new keys")
+	}
+
+	ks, err := ListKeys(dst)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	n := map[string]int{}
+	for _, k := range ks {
+		n[k.Keyring]++
+	}
+	if got, want := n["pub"], 2; got != want {
+		t.Fatalf("public keys: got %v, want %v\n%v", got, want, ks)
+	}
+	if got, want := n["sec"], 0; got != want {
+		t.Fatalf("secret keys: got %v, want %v", got, want)
+	}
+}
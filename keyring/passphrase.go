@@ -0,0 +1,94 @@
+package keyring
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// The environment variables consulted for secret key passphrases.
+const (
+	PassphraseEnv    = "PM_PGP_PASSPHRASE"
+	PassphraseFDEnv  = "PM_PGP_PASSPHRASE_FD"
+	NewPassphraseEnv = "PM_PGP_NEW_PASSPHRASE"
+)
+
+// Passphrase returns the passphrase used to unlock secret keys.
+//
+// It is read from the first line of the file descriptor named by
+// PM_PGP_PASSPHRASE_FD, then from PM_PGP_PASSPHRASE, and finally by prompting
+// on the controlling terminal.
+func Passphrase(prompt string) ([]byte, error) {
+	if fd := os.Getenv(PassphraseFDEnv); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %v", PassphraseFDEnv)
+		}
+		f := os.NewFile(uintptr(n), "passphrase")
+		if f == nil {
+			return nil, errors.Errorf("invalid passphrase file descriptor %d", n)
+		}
+		s := bufio.NewScanner(f)
+		s.Scan()
+		if err := s.Err(); err != nil {
+			return nil, errors.Wrap(err, "reading passphrase fd")
+		}
+		if err := f.Close(); err != nil {
+			return nil, errors.Wrap(err, "closing passphrase fd")
+		}
+		return s.Bytes(), nil
+	}
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	return prompt1(prompt)
+}
+
+// NewPassphrase returns a fresh, non-empty passphrase used to protect secret
+// keys.
+//
+// It is read from PM_PGP_NEW_PASSPHRASE, or by prompting twice on the
+// controlling terminal.
+func NewPassphrase(prompt string) ([]byte, error) {
+	if p := os.Getenv(NewPassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	a, err := prompt1(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if len(a) == 0 {
+		return nil, errors.New("passphrase cannot be empty")
+	}
+	b, err := prompt1("repeat " + prompt)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(a, b) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return a, nil
+}
+
+// prompt1 reads a single passphrase from the controlling terminal, so that it
+// works even when stdin is being used for data.
+func prompt1(prompt string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Errorf("no terminal to prompt for passphrase; set %v or %v", PassphraseEnv, PassphraseFDEnv)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	p, err := terminal.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading passphrase")
+	}
+	return p, nil
+}
@@ -0,0 +1,165 @@
+package keyring
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// Policy describes which signatures are required before a signed file is
+// trusted.
+type Policy struct {
+	// Threshold is the number of distinct keys that must have produced a
+	// valid signature.
+	Threshold int `json:"threshold"`
+
+	// Keys, if not empty, lists the fingerprints of the only keys that count
+	// towards Threshold.
+	Keys []string `json:"keys,omitempty"`
+}
+
+// NewPolicy returns a validated Policy requiring threshold signatures from
+// keys, which may be given in any format accepted for fingerprints.
+func NewPolicy(threshold int, keys []string) (Policy, error) {
+	p := Policy{Threshold: threshold}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		fp := normalizeID(k)
+		if len(fp) != 40 && len(fp) != 64 {
+			return p, fmt.Errorf("%q is not a full OpenPGP or ed25519 fingerprint", k)
+		}
+		if _, ok := seen[fp]; ok {
+			return p, fmt.Errorf("%q listed more than once", k)
+		}
+		seen[fp] = true
+		p.Keys = append(p.Keys, fp)
+	}
+	if _, err := p.Valid(); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// Valid validates the contents of a Policy.
+func (p Policy) Valid() (bool, error) {
+	if p.Threshold < 1 {
+		return false, errors.New("threshold must be at least 1")
+	}
+	if len(p.Keys) > 0 && p.Threshold > len(p.Keys) {
+		return false, fmt.Errorf("threshold %d can never be met by %d keys", p.Threshold, len(p.Keys))
+	}
+	return true, nil
+}
+
+// VerifyPolicy verifies the detached signatures in sig against file,
+// returning an error unless they satisfy p.
+//
+// sig may contain any number of concatenated OpenPGP armored or ed25519
+// signatures. Signatures by keys not in the keyring are ignored; invalid
+// signatures by known keys are an error.
+func VerifyPolicy(root string, file, sig io.Reader, p Policy) error {
+	if _, err := p.Valid(); err != nil {
+		return errors.Wrap(err, "invalid policy")
+	}
+	fps, err := Signers(root, file, sig)
+	if err != nil {
+		return err
+	}
+
+	allowed := map[string]bool{}
+	for _, k := range p.Keys {
+		allowed[k] = true
+	}
+	n := 0
+	for _, fp := range fps {
+		if len(allowed) > 0 && !allowed[fp] {
+			continue
+		}
+		n++
+	}
+	if n < p.Threshold {
+		return errors.Errorf("found %d of %d required signatures", n, p.Threshold)
+	}
+	return nil
+}
+
+// Signers returns the fingerprints of the keys in the keyring that produced
+// valid signatures in sig for file.
+func Signers(root string, file, sig io.Reader) ([]string, error) {
+	if err := ensureDir(root); err != nil {
+		return nil, errors.Wrap(err, "can't find or create pgp dir")
+	}
+	srn, prn := getNames(root)
+	_, pubs, err := getELs(srn, prn)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting existing keyrings")
+	}
+
+	eds, _, err := loadEd(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading ed25519 keys")
+	}
+
+	fps, err := signers(pubs, eds, file, sig)
+	if err != nil {
+		return nil, errors.Wrap(err, "checking signatures")
+	}
+	return fps, nil
+}
+
+// signers returns the fingerprints of the distinct keys in pubs and eds that
+// produced valid signatures in sig for file.
+func signers(pubs openpgp.EntityList, eds []edKey, file, sig io.Reader) ([]string, error) {
+	fb, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading signed file")
+	}
+	sb, err := ioutil.ReadAll(sig)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading signatures")
+	}
+	blocks, err := splitSigs(sb)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing signatures")
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New("no signatures found")
+	}
+
+	r := []string{}
+	seen := map[string]bool{}
+	for _, b := range blocks {
+		var fp string
+		switch b.kind {
+		case sigPGP:
+			e, err := openpgp.CheckArmoredDetachedSignature(pubs, bytes.NewReader(fb), bytes.NewReader(b.raw))
+			if err == pgperrors.ErrUnknownIssuer {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "check sig")
+			}
+			fp = Fingerprint(e)
+		case sigEd25519:
+			k, err := verifyEd(eds, fb, b.raw)
+			if err == errUnknownEdKey {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "check sig")
+			}
+			fp = k.Fingerprint()
+		}
+		if _, ok := seen[fp]; ok {
+			continue
+		}
+		seen[fp] = true
+		r = append(r, fp)
+	}
+	return r, nil
+}
@@ -0,0 +1,447 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
+
+	"mcquay.me/fs"
+)
+
+// tagSecretKey is the OpenPGP packet tag of a primary secret key; see RFC
+// 4880, section 4.3.
+const tagSecretKey = 5
+
+// secret is a single entity from the secret keyring along with the exact
+// bytes it was read from.
+//
+// x/crypto/openpgp can read encrypted secret keys but cannot write them, so
+// we hold on to the original bytes and only re-serialize entities whose key
+// material we have changed.
+type secret struct {
+	raw []byte
+	e   *openpgp.Entity
+}
+
+// Encrypted reports if any of e's secret key material is passphrase
+// protected.
+func Encrypted(e *openpgp.Entity) bool {
+	if e.PrivateKey != nil && e.PrivateKey.Encrypted {
+		return true
+	}
+	for _, sk := range e.Subkeys {
+		if sk.PrivateKey != nil && sk.PrivateKey.Encrypted {
+			return true
+		}
+	}
+	return false
+}
+
+// Decrypt unlocks all of e's secret key material using passphrase.
+func Decrypt(e *openpgp.Entity, passphrase []byte) error {
+	if e.PrivateKey == nil {
+		return errors.New("entity has no secret key material")
+	}
+	if err := e.PrivateKey.Decrypt(passphrase); err != nil {
+		return errors.Wrap(err, "decrypting primary key; bad passphrase?")
+	}
+	for _, sk := range e.Subkeys {
+		if sk.PrivateKey == nil {
+			continue
+		}
+		if err := sk.PrivateKey.Decrypt(passphrase); err != nil {
+			return errors.Wrapf(err, "decrypting subkey %v", sk.PublicKey.KeyIdShortString())
+		}
+	}
+	return nil
+}
+
+// ExportSecret writes the armored OpenPGP, or ed25519, secret key associated
+// with id to w.
+//
+// Only passphrase protected keys are exported; the key material is written
+// exactly as it is stored in the secret keyring.
+func ExportSecret(root string, w io.Writer, id string) error {
+	if err := ensureDir(root); err != nil {
+		return errors.Wrap(err, "can't find or create pgp dir")
+	}
+	_, esecs, err := loadEd(root)
+	if err != nil {
+		return errors.Wrap(err, "loading ed25519 keys")
+	}
+	keys := []edKey{}
+	for _, s := range esecs {
+		keys = append(keys, s.edKey)
+	}
+	if ks := findEd(keys, id); len(ks) == 1 {
+		for _, s := range esecs {
+			if s.Fingerprint() == ks[0].Fingerprint() {
+				return exportEdSecret(w, s)
+			}
+		}
+	}
+
+	srn, _ := getNames(root)
+	secs, err := readSecrets(srn)
+	if err != nil {
+		return errors.Wrap(err, "reading secring")
+	}
+	el := openpgp.EntityList{}
+	for _, s := range secs {
+		el = append(el, s.e)
+	}
+	e, err := findKey(el, id)
+	if err != nil {
+		return errors.Wrap(err, "find key")
+	}
+	if !Encrypted(e) {
+		return errors.Errorf("%v is not passphrase protected; set one with pm keyring passwd", e.PrimaryKey.KeyIdShortString())
+	}
+
+	aw, err := armor.Encode(w, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return errors.Wrap(err, "creating armor encoder")
+	}
+	for _, s := range secs {
+		if s.e != e {
+			continue
+		}
+		if _, err := aw.Write(s.raw); err != nil {
+			return errors.Wrap(err, "writing key")
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return errors.Wrap(err, "closing armor encoder")
+	}
+	fmt.Fprintf(w, "\n")
+	return nil
+}
+
+// ChangePassphrase re-encrypts the OpenPGP or ed25519 secret key identified
+// by id, which is currently protected by old, using new.
+func ChangePassphrase(root, id string, old, new []byte) error {
+	if len(new) == 0 {
+		return errors.New("new passphrase cannot be empty")
+	}
+	if err := ensureDir(root); err != nil {
+		return errors.Wrap(err, "can't find or create pgp dir")
+	}
+	_, esecs, err := loadEd(root)
+	if err != nil {
+		return errors.Wrap(err, "loading ed25519 keys")
+	}
+	keys := []edKey{}
+	for _, s := range esecs {
+		keys = append(keys, s.edKey)
+	}
+	if ks := findEd(keys, id); len(ks) == 1 {
+		return changeEdPassphrase(root, ks[0], old, new)
+	}
+
+	srn, _ := getNames(root)
+	secs, err := readSecrets(srn)
+	if err != nil {
+		return errors.Wrap(err, "reading secring")
+	}
+	el := openpgp.EntityList{}
+	for _, s := range secs {
+		el = append(el, s.e)
+	}
+	e, err := findKey(el, id)
+	if err != nil {
+		return errors.Wrapf(err, "finding key %q", id)
+	}
+	if err := Decrypt(e, old); err != nil {
+		return errors.Wrap(err, "unlocking key")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := serializeEncrypted(buf, e, new); err != nil {
+		return errors.Wrapf(err, "serializing %v", e.PrimaryKey.KeyIdString())
+	}
+	for i := range secs {
+		if secs[i].e == e {
+			secs[i].raw = buf.Bytes()
+		}
+	}
+	return writeSecrets(srn, secs)
+}
+
+// serializeEncrypted writes e, including its secret key material encrypted
+// with passphrase, to w. The key material in e must already be decrypted.
+//
+// Unlike Entity.SerializePrivate this does not re-sign identities or subkeys,
+// so the self-signatures in e must already be valid.
+func serializeEncrypted(w io.Writer, e *openpgp.Entity, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("passphrase cannot be empty")
+	}
+	if err := encryptKey(w, e.PrivateKey, passphrase); err != nil {
+		return errors.Wrap(err, "encrypting primary key")
+	}
+	for _, ident := range e.Identities {
+		if err := ident.UserId.Serialize(w); err != nil {
+			return errors.Wrap(err, "serializing user id")
+		}
+		if err := ident.SelfSignature.Serialize(w); err != nil {
+			return errors.Wrap(err, "serializing self signature")
+		}
+	}
+	for _, sk := range e.Subkeys {
+		if err := encryptKey(w, sk.PrivateKey, passphrase); err != nil {
+			return errors.Wrapf(err, "encrypting subkey %v", sk.PublicKey.KeyIdShortString())
+		}
+		if err := sk.Sig.Serialize(w); err != nil {
+			return errors.Wrap(err, "serializing subkey signature")
+		}
+	}
+	return nil
+}
+
+// encryptKey writes a secret key packet for pk to w with the key material
+// encrypted using AES-256 and an iterated and salted S2K derived from
+// passphrase, as described in RFC 4880, section 5.5.3.
+func encryptKey(w io.Writer, pk *packet.PrivateKey, passphrase []byte) error {
+	if pk.Encrypted {
+		return errors.New("key material must be decrypted first")
+	}
+
+	plain := &bytes.Buffer{}
+	if err := pk.Serialize(plain); err != nil {
+		return errors.Wrap(err, "serializing private key")
+	}
+	tag, body, _, err := nextPacket(plain.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "parsing private key packet")
+	}
+	pub := &bytes.Buffer{}
+	if err := pk.PublicKey.Serialize(pub); err != nil {
+		return errors.Wrap(err, "serializing public key")
+	}
+	_, pubBody, _, err := nextPacket(pub.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "parsing public key packet")
+	}
+	// body is: public key, s2k usage (0), key material, 2 byte checksum
+	if len(body) < len(pubBody)+3 || !bytes.Equal(body[:len(pubBody)], pubBody) || body[len(pubBody)] != 0 {
+		return errors.New("unexpected private key packet layout")
+	}
+	material := body[len(pubBody)+1 : len(body)-2]
+
+	out := &bytes.Buffer{}
+	out.Write(pubBody)
+	out.WriteByte(254) // s2k usage; sha1 checksum follows key material
+	out.WriteByte(byte(packet.CipherAES256))
+	key := make([]byte, packet.CipherAES256.KeySize())
+	if err := s2k.Serialize(out, key, rand.Reader, passphrase, &s2k.Config{Hash: crypto.SHA256}); err != nil {
+		return errors.Wrap(err, "s2k")
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return errors.Wrap(err, "generating iv")
+	}
+	out.Write(iv)
+
+	sum := sha1.Sum(material)
+	ct := append(append([]byte{}, material...), sum[:]...)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return errors.Wrap(err, "creating cipher")
+	}
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(ct, ct)
+	out.Write(ct)
+
+	if err := writeHeader(w, tag, out.Len()); err != nil {
+		return errors.Wrap(err, "writing packet header")
+	}
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return errors.Wrap(err, "writing packet body")
+	}
+	return nil
+}
+
+// nextPacket parses the OpenPGP packet at the start of b, returning its tag,
+// its body, and the remainder of b.
+func nextPacket(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("short packet header")
+	}
+	if b[0]&0x80 == 0 {
+		return 0, nil, nil, errors.New("tag byte does not have MSB set")
+	}
+
+	var tag byte
+	var hl, bl int
+	if b[0]&0x40 == 0 {
+		// old format
+		tag = (b[0] & 0x3f) >> 2
+		switch b[0] & 3 {
+		case 0:
+			hl, bl = 2, int(b[1])
+		case 1:
+			if len(b) < 3 {
+				return 0, nil, nil, errors.New("short packet header")
+			}
+			hl, bl = 3, int(b[1])<<8|int(b[2])
+		case 2:
+			if len(b) < 5 {
+				return 0, nil, nil, errors.New("short packet header")
+			}
+			hl, bl = 5, int(b[1])<<24|int(b[2])<<16|int(b[3])<<8|int(b[4])
+		default:
+			return 0, nil, nil, errors.New("indeterminate length packets are not supported")
+		}
+	} else {
+		// new format
+		tag = b[0] & 0x3f
+		switch {
+		case b[1] < 192:
+			hl, bl = 2, int(b[1])
+		case b[1] < 224:
+			if len(b) < 3 {
+				return 0, nil, nil, errors.New("short packet header")
+			}
+			hl, bl = 3, (int(b[1])-192)<<8+int(b[2])+192
+		case b[1] == 255:
+			if len(b) < 6 {
+				return 0, nil, nil, errors.New("short packet header")
+			}
+			hl, bl = 6, int(b[2])<<24|int(b[3])<<16|int(b[4])<<8|int(b[5])
+		default:
+			return 0, nil, nil, errors.New("partial length packets are not supported")
+		}
+	}
+	if len(b) < hl+bl {
+		return 0, nil, nil, errors.Errorf("truncated packet; want %d bytes, have %d", hl+bl, len(b))
+	}
+	return tag, b[hl : hl+bl], b[hl+bl:], nil
+}
+
+// writeHeader writes a new format packet header; see RFC 4880, section 4.2.
+func writeHeader(w io.Writer, tag byte, length int) error {
+	var hdr []byte
+	switch {
+	case length < 192:
+		hdr = []byte{0xc0 | tag, byte(length)}
+	case length < 8384:
+		length -= 192
+		hdr = []byte{0xc0 | tag, byte(length>>8) + 192, byte(length)}
+	default:
+		hdr = []byte{0xc0 | tag, 255, byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)}
+	}
+	_, err := w.Write(hdr)
+	return err
+}
+
+// readSecrets parses the secret keyring at fn into its constituent entities.
+func readSecrets(fn string) ([]secret, error) {
+	if !fs.Exists(fn) {
+		return []secret{}, nil
+	}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading secring")
+	}
+	return parseSecrets(b)
+}
+
+// parseSecrets splits the binary secret keys in b into their constituent
+// entities.
+func parseSecrets(b []byte) ([]secret, error) {
+	r := []secret{}
+
+	// split the keyring at every primary secret key packet.
+	starts := []int{}
+	for rest := b; len(rest) > 0; {
+		tag, _, next, err := nextPacket(rest)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing secring")
+		}
+		if tag == tagSecretKey {
+			starts = append(starts, len(b)-len(rest))
+		}
+		rest = next
+	}
+	if len(starts) == 0 {
+		return r, nil
+	}
+	if starts[0] != 0 {
+		return nil, errors.New("key material does not start with a secret key")
+	}
+	starts = append(starts, len(b))
+
+	for i := 0; i < len(starts)-1; i++ {
+		raw := b[starts[i]:starts[i+1]]
+		e, err := openpgp.ReadEntity(packet.NewReader(bytes.NewReader(raw)))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading entity")
+		}
+		r = append(r, secret{raw: raw, e: e})
+	}
+	return r, nil
+}
+
+// addSecrets appends the entities in ss that are not already present to the
+// secret keyring at fn, returning how many were added.
+func addSecrets(fn string, ss []secret) (int, error) {
+	secs, err := readSecrets(fn)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading secring")
+	}
+	exist := map[string]bool{}
+	for _, s := range secs {
+		exist[Fingerprint(s.e)] = true
+	}
+	added := 0
+	for _, s := range ss {
+		if _, ok := exist[Fingerprint(s.e)]; ok {
+			continue
+		}
+		exist[Fingerprint(s.e)] = true
+		secs = append(secs, s)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, writeSecrets(fn, secs)
+}
+
+// writeSecrets atomically replaces the secret keyring at fn with secs.
+func writeSecrets(fn string, secs []secret) error {
+	dir, _ := filepath.Split(fn)
+	f, err := ioutil.TempFile(dir, "secring-")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary secring")
+	}
+	for _, s := range secs {
+		if _, err := f.Write(s.raw); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return errors.Wrapf(err, "writing %v", s.e.PrimaryKey.KeyIdString())
+		}
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "closing temporary secring")
+	}
+	if err := os.Rename(f.Name(), fn); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "replacing secring")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package keyring
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func dirMe(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "pm-keyring-tests-")
+	if err != nil {
+		t.Fatalf("tmpdir: %v", err)
+	}
+	return root, func() {
+		if err := os.RemoveAll(root); err != nil {
+			t.Fatalf("cleanup: %v", err)
+		}
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	root, del := dirMe(t)
+	defer del()
+
+	if err := NewKeyPair(root, "a", "a@example.com", nil); err == nil {
+		t.Fatalf("should not be able to create a key without a passphrase")
+	}
+	if err := NewKeyPair(root, "a", "a@example.com", []byte("first")); err != nil {
+		t.Fatalf("new key pair: %v", err)
+	}
+	if err := NewKeyPair(root, "b", "b@example.com", []byte("second")); err != nil {
+		t.Fatalf("new key pair: %v", err)
+	}
+
+	e, err := FindSecretEntity(root, "a@example.com")
+	if err != nil {
+		t.Fatalf("find secret: %v", err)
+	}
+	if !Encrypted(e) {
+		t.Fatalf("secret key was stored unencrypted")
+	}
+	if err := Sign(e, bytes.NewBufferString("hi"), ioutil.Discard); err == nil {
+		t.Fatalf("should not be able to sign with encrypted key")
+	}
+	if err := Decrypt(e, []byte("wrong")); err == nil {
+		t.Fatalf("decrypted with wrong passphrase")
+	}
+
+	if err := ChangePassphrase(root, "a@example.com", []byte("first"), []byte("third")); err != nil {
+		t.Fatalf("change passphrase: %v", err)
+	}
+
+	e, err = FindSecretEntity(root, "a@example.com")
+	if err != nil {
+		t.Fatalf("find secret: %v", err)
+	}
+	if err := Decrypt(e, []byte("first")); err == nil {
+		t.Fatalf("old passphrase still works")
+	}
+	if err := Decrypt(e, []byte("third")); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	sig := &bytes.Buffer{}
+	if err := Sign(e, bytes.NewBufferString("hi"), sig); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if err := Verify(root, bytes.NewBufferString("hi"), sig); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// the other key must survive the rewrite untouched.
+	e, err = FindSecretEntity(root, "b@example.com")
+	if err != nil {
+		t.Fatalf("find secret: %v", err)
+	}
+	if err := Decrypt(e, []byte("second")); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+}
+
+func TestExportSecret(t *testing.T) {
+	src, del := dirMe(t)
+	defer del()
+	dst, del := dirMe(t)
+	defer del()
+
+	if err := NewKeyPair(src, "a", "a@example.com", []byte("pass")); err != nil {
+		t.Fatalf("new key pair: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ExportSecret(src, buf, "a@example.com"); err != nil {
+		t.Fatalf("export secret: %v", err)
+	}
+	if err := Import(dst, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if err := Import(dst, bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatalf("importing twice should fail")
+	}
+
+	e, err := FindSecretEntity(dst, "a@example.com")
+	if err != nil {
+		t.Fatalf("find secret: %v", err)
+	}
+	if err := Decrypt(e, []byte("pass")); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	sig := &bytes.Buffer{}
+	if err := Sign(e, bytes.NewBufferString("hi"), sig); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	// the public part must also have been imported for verification.
+	if err := Verify(dst, bytes.NewBufferString("hi"), sig); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+}
+
+func TestExportEd25519Secret(t *testing.T) {
+	src, del := dirMe(t)
+	defer del()
+	dst, del := dirMe(t)
+	defer del()
+
+	if err := NewEd25519KeyPair(src, "c", "c@example.com", []byte("pass")); err != nil {
+		t.Fatalf("new key pair: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ExportSecret(src, buf, "c@example.com"); err != nil {
+		t.Fatalf("export secret: %v", err)
+	}
+	if err := Import(dst, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if err := Import(dst, bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatalf("importing twice should fail")
+	}
+
+	if _, err := FindSigner(dst, "c@example.com", func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+		t.Fatalf("unlocked with wrong passphrase")
+	}
+	s, err := FindSigner(dst, "c@example.com", func() ([]byte, error) { return []byte("pass"), nil })
+	if err != nil {
+		t.Fatalf("find signer: %v", err)
+	}
+	sig := &bytes.Buffer{}
+	if err := s.Sign(bytes.NewBufferString("hi"), sig); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	// the public part must also have been imported for verification.
+	if err := Verify(dst, bytes.NewBufferString("hi"), sig); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+}
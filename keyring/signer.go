@@ -0,0 +1,191 @@
+package keyring
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+const (
+	sigHeader = "-----BEGIN PGP SIGNATURE-----"
+	sigFooter = "-----END PGP SIGNATURE-----"
+)
+
+// Signer produces detached signatures, e.g. for package manifests.
+//
+// pm supports OpenPGP keys and minisign-style ed25519 keys; which is used is
+// determined by the key selected for signing.
+type Signer interface {
+	// Sign writes a detached signature for the contents of in to sig.
+	Sign(in io.Reader, sig io.Writer) error
+
+	// Fingerprint returns the full hex fingerprint of the signing key, as
+	// used in signature policies.
+	Fingerprint() string
+
+	// KeyID returns the hex key id embedded in the signatures made by this
+	// Signer.
+	KeyID() string
+}
+
+// pgpSigner signs with an unlocked OpenPGP entity.
+type pgpSigner struct {
+	e *openpgp.Entity
+}
+
+func (s *pgpSigner) Sign(in io.Reader, sig io.Writer) error { return Sign(s.e, in, sig) }
+func (s *pgpSigner) Fingerprint() string                    { return Fingerprint(s.e) }
+func (s *pgpSigner) KeyID() string                          { return s.e.PrimaryKey.KeyIdString() }
+
+// FindSigner searches both the OpenPGP and ed25519 secret keys for id and
+// returns a Signer for it. passphrase is called only if the key is
+// encrypted.
+func FindSigner(root, id string, passphrase func() ([]byte, error)) (Signer, error) {
+	_, secs, err := loadEd(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading ed25519 keys")
+	}
+	keys := []edKey{}
+	for _, s := range secs {
+		keys = append(keys, s.edKey)
+	}
+	eds := findEd(keys, id)
+	if len(eds) > 1 {
+		return nil, errors.New("too many keys matched; try searching by full fingerprint?")
+	}
+
+	e, perr := FindSecretEntity(root, id)
+	if len(eds) == 1 && perr == nil {
+		return nil, fmt.Errorf("%q matches both OpenPGP and ed25519 keys; try searching by full fingerprint?", id)
+	}
+
+	if len(eds) == 1 {
+		for _, s := range secs {
+			if s.Fingerprint() != eds[0].Fingerprint() {
+				continue
+			}
+			pass, err := passphrase()
+			if err != nil {
+				return nil, errors.Wrap(err, "getting passphrase")
+			}
+			priv, err := s.unlock(pass)
+			if err != nil {
+				return nil, err
+			}
+			return &edSigner{k: s.edKey, priv: priv}, nil
+		}
+	}
+
+	if perr != nil {
+		return nil, perr
+	}
+	if Encrypted(e) {
+		pass, err := passphrase()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting passphrase")
+		}
+		if err := Decrypt(e, pass); err != nil {
+			return nil, err
+		}
+	}
+	return &pgpSigner{e}, nil
+}
+
+type sigKind int
+
+const (
+	sigPGP sigKind = iota
+	sigEd25519
+)
+
+// sigBlock is a single detached signature.
+type sigBlock struct {
+	kind sigKind
+	raw  []byte
+}
+
+// issuer returns the hex key id of the key that made b.
+func (b sigBlock) issuer() (string, error) {
+	if b.kind == sigEd25519 {
+		s, err := parseEdSig(b.raw)
+		if err != nil {
+			return "", err
+		}
+		return s.id, nil
+	}
+
+	block, err := armor.Decode(bytes.NewReader(b.raw))
+	if err != nil {
+		return "", errors.Wrap(err, "decoding armor")
+	}
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "reading signature packet")
+	}
+	switch s := p.(type) {
+	case *packet.Signature:
+		if s.IssuerKeyId == nil {
+			return "", nil
+		}
+		return fmt.Sprintf("%016X", *s.IssuerKeyId), nil
+	case *packet.SignatureV3:
+		return fmt.Sprintf("%016X", s.IssuerKeyId), nil
+	}
+	return "", errors.Errorf("unexpected packet %T in signature", p)
+}
+
+// splitSigs splits b into its constituent OpenPGP armored and ed25519
+// signatures.
+func splitSigs(b []byte) ([]sigBlock, error) {
+	r := []sigBlock{}
+	lines := strings.SplitAfter(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		l := strings.TrimSpace(lines[i])
+		switch {
+		case l == "":
+		case l == sigHeader:
+			j := i
+			for j < len(lines) && strings.TrimSpace(lines[j]) != sigFooter {
+				j++
+			}
+			if j == len(lines) {
+				return nil, errors.New("unterminated armored signature")
+			}
+			r = append(r, sigBlock{kind: sigPGP, raw: []byte(strings.Join(lines[i:j+1], ""))})
+			i = j
+		case strings.HasPrefix(l, untrustedPrefix):
+			if i+4 > len(lines) {
+				return nil, errors.New("truncated ed25519 signature")
+			}
+			r = append(r, sigBlock{kind: sigEd25519, raw: []byte(strings.Join(lines[i:i+4], ""))})
+			i += 3
+		default:
+			return nil, errors.Errorf("unexpected content in signatures: %q", l)
+		}
+	}
+	return r, nil
+}
+
+// Signed reports if s has already produced one of the signatures in sig.
+func Signed(s Signer, sig []byte) (bool, error) {
+	blocks, err := splitSigs(sig)
+	if err != nil {
+		return false, errors.Wrap(err, "parsing signatures")
+	}
+	for _, b := range blocks {
+		id, err := b.issuer()
+		if err != nil {
+			return false, err
+		}
+		if id == s.KeyID() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 )
 
 // Meta tracks metadata for a package
@@ -12,7 +13,18 @@ type Meta struct {
 	Version     Version `json:"version"`
 	Description string  `json:"description"`
 
+	// Deps lists the labels (name or name@version) of the packages this
+	// package depends on.
+	Deps []string `json:"deps,omitempty" yaml:"deps,omitempty"`
+
 	Remote url.URL `json:"remote"`
+
+	// File is the path of the .pkg this was installed from, if it was
+	// installed from a local file rather than a remote.
+	File string `json:"file,omitempty" yaml:"-"`
+
+	// Digest is the hex encoded sha256 of the .pkg, if known.
+	Digest string `json:"digest,omitempty" yaml:"-"`
 }
 
 // Valid validates the contents of a Meta for requires fields.
@@ -20,6 +32,10 @@ func (m Meta) Valid() (bool, error) {
 	if m.Name == "" {
 		return false, errors.New("name cannot be empty")
 	}
+	// names are used as paths, e.g. of the package's installed metadata.
+	if strings.ContainsAny(string(m.Name), `/\`) || strings.Contains(string(m.Name), "..") || m.Name == "." {
+		return false, fmt.Errorf("name %q cannot contain a path separator or ..", m.Name)
+	}
 	if m.Version == "" {
 		return false, errors.New("version cannot be empty")
 	}
@@ -39,6 +55,14 @@ func (m Meta) URL() string {
 	return fmt.Sprintf("%s/%s", m.Remote.String(), m.Pkg())
 }
 
+// Source returns where the package came from: its local file, or its remote.
+func (m Meta) Source() string {
+	if m.File != "" {
+		return m.File
+	}
+	return m.Remote.String()
+}
+
 func (m Meta) String() string {
 	return m.URL()
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"net/url"
+	"reflect"
 	"testing"
 )
 
@@ -31,6 +33,24 @@ func TestValid(t *testing.T) {
 			},
 			err: errors.New("name"),
 		},
+		{
+			label: "name with separator",
+			m: Meta{
+				Name:        "../../etc/heat",
+				Version:     "1.1.0",
+				Description: "some description",
+			},
+			err: errors.New("name"),
+		},
+		{
+			label: "name with dot dot",
+			m: Meta{
+				Name:        "..",
+				Version:     "1.1.0",
+				Description: "some description",
+			},
+			err: errors.New("name"),
+		},
 		{
 			label: "missing version",
 			m: Meta{
@@ -68,6 +88,7 @@ func TestJsonRoundTrip(t *testing.T) {
 		Name:        "heat",
 		Version:     "1.1.0",
 		Description: "make heat using cpus",
+		Deps:        []string{"cpu", "fan@1.0.0"},
 	}
 
 	buf := &bytes.Buffer{}
@@ -79,7 +100,23 @@ func TestJsonRoundTrip(t *testing.T) {
 	if err := json.NewDecoder(buf).Decode(&a); err != nil {
 		t.Fatalf("decode: %v", err)
 	}
-	if b != a {
+	if !reflect.DeepEqual(a, b) {
 		t.Fatalf("a != b: %v != %v", a, b)
 	}
 }
+
+func TestSource(t *testing.T) {
+	m := Meta{
+		Name:        "heat",
+		Version:     "1.1.0",
+		Description: "make heat using cpus",
+		Remote:      url.URL{Scheme: "https", Host: "pm.mcquay.me", Path: "/darwin/amd64"},
+	}
+	if got, want := m.Source(), "https://pm.mcquay.me/darwin/amd64"; got != want {
+		t.Fatalf("remote source: got %v, want %v", got, want)
+	}
+	m.File = "/tmp/heat-1.1.0.pkg"
+	if got, want := m.Source(), m.File; got != want {
+		t.Fatalf("file source: got %v, want %v", got, want)
+	}
+}
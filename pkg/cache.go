@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+	"mcquay.me/pm"
+)
+
+const cache = "var/cache/pm"
+
+// KeepCache, if true, leaves packages in the cache once they are installed,
+// so that reinstalling them need not fetch them again.
+var KeepCache bool
+
+// cachedName matches the names of the .pkg files in the cache, which are
+// keyed by digest.
+var cachedName = regexp.MustCompile(`^[0-9a-f]{64}\.pkg$`)
+
+// cached returns the location of m in the cache below root.
+func cached(root string, m pm.Meta) string {
+	return filepath.Join(root, cache, m.Digest+".pkg")
+}
+
+// CachedPackage is a downloaded package, as listed by ListCache.
+type CachedPackage struct {
+	Name    pm.Name    `json:"name"`
+	Version pm.Version `json:"version"`
+	Digest  string     `json:"digest"`
+
+	// Size is in bytes.
+	Size int64 `json:"size"`
+}
+
+// ListCache returns the packages in the cache below root.
+func ListCache(root string) ([]CachedPackage, error) {
+	ms, err := cachedMetas(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cache")
+	}
+	r := []CachedPackage{}
+	for _, m := range ms {
+		fi, err := os.Stat(cached(root, m))
+		if err != nil {
+			return nil, errors.Wrap(err, "stat")
+		}
+		r = append(r, CachedPackage{Name: m.Name, Version: m.Version, Digest: m.Digest, Size: fi.Size()})
+	}
+	return r, nil
+}
+
+// CleanCache removes everything from the cache below root, including
+// partial downloads.
+func CleanCache(root string) error {
+	dir := filepath.Join(root, cache)
+	if !fs.Exists(dir) {
+		return nil
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "reading cache dir")
+	}
+	for _, fi := range fis {
+		if err := os.RemoveAll(filepath.Join(dir, fi.Name())); err != nil {
+			return errors.Wrapf(err, "removing %v", fi.Name())
+		}
+	}
+	return nil
+}
+
+// PruneCache removes all but the newest keep versions of each package from
+// the cache below root, and returns what it removed.
+func PruneCache(root string, keep int) (pm.Metas, error) {
+	if keep < 0 {
+		return nil, errors.New("keep cannot be negative")
+	}
+	ms, err := cachedMetas(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cache")
+	}
+
+	// cachedMetas sorts newest first within each name.
+	pruned := pm.Metas{}
+	n, seen := pm.Name(""), 0
+	for _, m := range ms {
+		if m.Name != n {
+			n, seen = m.Name, 0
+		}
+		seen++
+		if seen <= keep {
+			continue
+		}
+		if err := os.Remove(cached(root, m)); err != nil {
+			return pruned, errors.Wrapf(err, "removing %v", m.Digest)
+		}
+		pruned = append(pruned, m)
+	}
+	return pruned, nil
+}
+
+// cachedMetas returns the packages in the cache below root, ordered by name
+// and then newest version first.
+func cachedMetas(root string) (pm.Metas, error) {
+	dir := filepath.Join(root, cache)
+	if !fs.Exists(dir) {
+		return nil, nil
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cache dir")
+	}
+	ms := pm.Metas{}
+	for _, fi := range fis {
+		if !cachedName.MatchString(fi.Name()) {
+			continue
+		}
+		m, err := ReadMeta(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %v", fi.Name())
+		}
+		m.Digest = strings.TrimSuffix(fi.Name(), ".pkg")
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Name != ms[j].Name {
+			return ms[i].Name < ms[j].Name
+		}
+		return ms[i].Version.Compare(ms[j].Version) > 0
+	})
+	return ms, nil
+}
+
+// cachedDigest returns the digest of the one package in ms named n at
+// version v, or "" if there is none or several.
+func cachedDigest(ms pm.Metas, n pm.Name, v pm.Version) string {
+	d := ""
+	for _, m := range ms {
+		if m.Name != n || m.Version != v {
+			continue
+		}
+		if d != "" {
+			return ""
+		}
+		d = m.Digest
+	}
+	return d
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mcquay.me/fs"
+	"mcquay.me/pm"
+	"mcquay.me/pm/internal/pmtest"
+)
+
+func TestPruneCache(t *testing.T) {
+	root, done := pmtest.Dir(t)
+	defer done()
+
+	dir := filepath.Join(root, cache)
+	for _, v := range []string{"1.2.0", "1.9.0", "1.10.0"} {
+		m := pm.Meta{}
+		if err := store(dir, pmtest.FakePkg(t, dir, "foo", v), &m); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+	}
+	m := pm.Meta{}
+	if err := store(dir, pmtest.FakePkg(t, dir, "bar", "1.0.0"), &m); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	pruned, err := PruneCache(root, 1)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	got := []string{}
+	for _, m := range pruned {
+		got = append(got, string(m.Name)+"@"+string(m.Version))
+	}
+	if want := "foo@1.9.0 foo@1.2.0"; strings.Join(got, " ") != want {
+		t.Fatalf("pruned: got %v, want %v", got, want)
+	}
+	cs, err := ListCache(root)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if got, want := len(cs), 2; got != want {
+		t.Fatalf("cached: got %v, want %v", got, want)
+	}
+	for _, c := range cs {
+		if c.Name == "foo" && c.Version != "1.10.0" {
+			t.Fatalf("kept foo@%v, want foo@1.10.0", c.Version)
+		}
+	}
+}
+
+func TestStoreDigest(t *testing.T) {
+	root, done := pmtest.Dir(t)
+	defer done()
+
+	dir := filepath.Join(root, cache)
+	pn := pmtest.FakePkg(t, dir, "foo", "1.0.0")
+	m := pm.Meta{Digest: strings.Repeat("0", 64)}
+	if err := store(dir, pn, &m); err == nil {
+		t.Fatalf("stored package with the wrong digest")
+	}
+	if fs.Exists(pn) {
+		t.Fatalf("kept package with the wrong digest")
+	}
+	if fs.Exists(filepath.Join(dir, m.Digest+".pkg")) {
+		t.Fatalf("cached package with the wrong digest")
+	}
+
+	pn = pmtest.FakePkg(t, dir, "foo", "1.0.0")
+	d, err := Digest(pn)
+	if err != nil {
+		t.Fatalf("digest: %v", err)
+	}
+	m = pm.Meta{Digest: d}
+	if err := store(dir, pn, &m); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	if !fs.Exists(cached(root, m)) {
+		t.Fatalf("package not cached")
+	}
+}
@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"mcquay.me/fs"
+	"mcquay.me/pm"
+	"mcquay.me/pm/keyring"
+)
+
+// Cosign adds a signature by key to the manifest of the existing .pkg file
+// pn, leaving all other contents untouched.
+func Cosign(key keyring.Signer, pn string) error {
+	if !fs.Exists(pn) {
+		return fmt.Errorf("%q: doesn't exist", pn)
+	}
+	if err := checkManifest(pn); err != nil {
+		return errors.Wrap(err, "checking manifest")
+	}
+
+	man, err := readFile(pn, "manifest.sha256")
+	if err != nil {
+		return errors.Wrap(err, "reading manifest")
+	}
+	sigs, err := readFile(pn, "manifest.sha256.asc")
+	if err != nil {
+		return errors.Wrap(err, "reading manifest signatures")
+	}
+	signed, err := keyring.Signed(key, sigs)
+	if err != nil {
+		return errors.Wrap(err, "checking existing signatures")
+	}
+	if signed {
+		return errors.Errorf("%q already signed by %v", pn, key.Fingerprint())
+	}
+
+	sig := bytes.NewBuffer(sigs)
+	if err := key.Sign(bytes.NewReader(man), sig); err != nil {
+		return errors.Wrap(err, "signing")
+	}
+
+	return replaceFile(pn, "manifest.sha256.asc", sig.Bytes())
+}
+
+// Verify checks that the contents of the .pkg at pn match its manifest, and
+// that the manifest signatures satisfy p using the keyring below root.
+func Verify(root, pn string, p keyring.Policy) error {
+	if err := checkManifest(pn); err != nil {
+		return errors.Wrap(err, "checking manifest")
+	}
+	man, err := readFile(pn, "manifest.sha256")
+	if err != nil {
+		return errors.Wrap(err, "reading manifest")
+	}
+	sigs, err := readFile(pn, "manifest.sha256.asc")
+	if err != nil {
+		return errors.Wrap(err, "reading manifest signatures")
+	}
+	if err := keyring.VerifyPolicy(root, bytes.NewReader(man), bytes.NewReader(sigs), p); err != nil {
+		return errors.Wrap(err, "verifying manifest")
+	}
+	return nil
+}
+
+// checkManifest verifies that the contents of the .pkg at pn match its
+// manifest.
+func checkManifest(pn string) error {
+	man, err := getReadCloser(pn, "manifest.sha256")
+	if err != nil {
+		return errors.Wrap(err, "getting manifest reader")
+	}
+	cs, err := pm.ParseCS(man)
+	if err != nil {
+		return errors.Wrap(err, "parsing manifest")
+	}
+	if err := man.Close(); err != nil {
+		return errors.Wrap(err, "closing manifest reader")
+	}
+
+	pf, err := os.Open(pn)
+	if err != nil {
+		return errors.Wrap(err, "opening pkg file")
+	}
+	defer pf.Close()
+	found := map[string]bool{}
+	tr := tar.NewReader(pf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "tar traversal")
+		}
+		if hdr.FileInfo().IsDir() || hdr.Name == "manifest.sha256" || hdr.Name == "manifest.sha256.asc" {
+			continue
+		}
+		sha, ok := cs[hdr.Name]
+		if !ok {
+			return errors.Errorf("extra file %q found in tarfile!", hdr.Name)
+		}
+		s := sha256.New()
+		if n, err := io.Copy(s, tr); err != nil {
+			return errors.Wrapf(err, "checksumming %q after %v bytes", hdr.Name, n)
+		}
+		if sha != fmt.Sprintf("%x", s.Sum(nil)) {
+			return errors.Errorf("%q checksum was incorrect", hdr.Name)
+		}
+		found[hdr.Name] = true
+	}
+	for n := range cs {
+		if _, ok := found[n]; !ok {
+			return errors.Errorf("%q missing from tarfile", n)
+		}
+	}
+	return nil
+}
+
+// readFile returns the contents of fn from the .pkg at pn.
+func readFile(pn, fn string) ([]byte, error) {
+	rc, err := getReadCloser(pn, fn)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		rc.Close()
+		return nil, errors.Wrapf(err, "reading %q", fn)
+	}
+	return b, rc.Close()
+}
+
+// replaceFile atomically rewrites the .pkg at pn with the contents of fn
+// replaced by b.
+func replaceFile(pn, fn string, b []byte) error {
+	pf, err := os.Open(pn)
+	if err != nil {
+		return errors.Wrap(err, "opening pkg file")
+	}
+	defer pf.Close()
+
+	dir, _ := filepath.Split(pn)
+	if dir == "" {
+		dir = "."
+	}
+	tf, err := ioutil.TempFile(dir, ".pkg-")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary pkg")
+	}
+	cleanup := func() {
+		tf.Close()
+		os.Remove(tf.Name())
+	}
+
+	tr := tar.NewReader(pf)
+	tw := tar.NewWriter(tf)
+	replaced := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cleanup()
+			return errors.Wrap(err, "tar traversal")
+		}
+		var r io.Reader = tr
+		if hdr.Name == fn {
+			hdr.Size = int64(len(b))
+			r = bytes.NewReader(b)
+			replaced = true
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			cleanup()
+			return errors.Wrapf(err, "writing tar header for %v", hdr.Name)
+		}
+		if c, err := io.Copy(tw, r); err != nil {
+			cleanup()
+			return errors.Wrapf(err, "copy %v after %d bytes", hdr.Name, c)
+		}
+	}
+	if !replaced {
+		cleanup()
+		return errors.Errorf("%q not found", fn)
+	}
+	if err := tw.Close(); err != nil {
+		cleanup()
+		return errors.Wrap(err, "closing tar writer")
+	}
+	if err := tf.Close(); err != nil {
+		os.Remove(tf.Name())
+		return errors.Wrap(err, "closing temporary pkg")
+	}
+	if err := os.Chmod(tf.Name(), 0644); err != nil {
+		os.Remove(tf.Name())
+		return errors.Wrap(err, "chmod temporary pkg")
+	}
+	if err := os.Rename(tf.Name(), pn); err != nil {
+		os.Remove(tf.Name())
+		return errors.Wrap(err, "replacing pkg")
+	}
+	return nil
+}
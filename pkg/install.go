@@ -9,11 +9,11 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"mcquay.me/fs"
@@ -22,78 +22,224 @@ import (
 	"mcquay.me/pm/keyring"
 )
 
-const cache = "var/cache/pm"
 const installed = "var/lib/pm/installed"
 
 // Install fetches and installs pkgs from appropriate remotes.
+//
+// Any of pkgs that name an existing .pkg file are installed from that file
+// instead.
 func Install(root string, pkgs []string) error {
-	av, err := db.LoadAvailable(root)
+	ms, err := fetch(root, pkgs)
 	if err != nil {
-		return errors.Wrap(err, "loading available db")
+		return err
 	}
+	for _, m := range ms {
+		if err := install(root, m); err != nil {
+			return errors.Wrapf(err, "installing %v", m.Name)
+		}
+	}
+	return nil
+}
+
+// Download fetches pkgs into the package cache without installing them, so
+// that a later Install need not.
+func Download(root string, pkgs []string) error {
+	_, err := fetch(root, pkgs)
+	return err
+}
 
-	ms, err := av.Installable(pkgs)
+// fetch resolves pkgs, as given to Install, and ensures they are in the
+// package cache. Held packages are skipped.
+func fetch(root string, pkgs []string) (pm.Metas, error) {
+	pins, err := db.LoadPins(root)
 	if err != nil {
-		return errors.Wrap(err, "checking ability to install")
+		return nil, errors.Wrap(err, "loading pins")
+	}
+	names, files := []string{}, []string{}
+	for _, p := range pkgs {
+		if strings.HasSuffix(p, ".pkg") && fs.Exists(p) && !fs.IsDir(p) {
+			files = append(files, p)
+			continue
+		}
+		n, v, err := pm.ParseLabel(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing name/version")
+		}
+		held, v := pins.Held(n, v)
+		if held {
+			log.Printf("skipping held package %v", p)
+			continue
+		}
+		if v != "" {
+			p = fmt.Sprintf("%v@%v", n, v)
+		}
+		names = append(names, p)
+	}
+
+	ms := pm.Metas{}
+	if len(names) > 0 {
+		av, err := db.LoadAvailable(root)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading available db")
+		}
+
+		ms, err = av.Installable(names)
+		if err != nil {
+			return nil, errors.Wrap(err, "checking ability to install")
+		}
+	}
+	seen := map[pm.Name]bool{}
+	for _, m := range ms {
+		seen[m.Name] = true
+	}
+	locals := pm.Metas{}
+	for _, fn := range files {
+		m, err := ReadMeta(fn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %q", fn)
+		}
+		if held, _ := pins.Held(m.Name, m.Version); held {
+			log.Printf("skipping held package %v", fn)
+			continue
+		}
+		if seen[m.Name] {
+			return nil, fmt.Errorf("can only ask to install %q once", m.Name)
+		}
+		seen[m.Name] = true
+		if m.File, err = filepath.Abs(fn); err != nil {
+			return nil, errors.Wrap(err, "absolute path")
+		}
+		locals = append(locals, m)
 	}
 
 	cacheDir := filepath.Join(root, cache)
 	if !fs.Exists(cacheDir) {
 		if err := os.MkdirAll(cacheDir, 0755); err != nil {
-			return errors.Wrap(err, "creating non-existent cache dir")
+			return nil, errors.Wrap(err, "creating non-existent cache dir")
 		}
 	}
 	if !fs.IsDir(cacheDir) {
-		return errors.Errorf("%q is not a directory!", cacheDir)
+		return nil, errors.Errorf("%q is not a directory!", cacheDir)
 	}
 	installedDir := filepath.Join(root, installed)
 	if !fs.Exists(installedDir) {
 		if err := os.MkdirAll(installedDir, 0755); err != nil {
-			return errors.Wrap(err, "creating non-existent cache dir")
+			return nil, errors.Wrap(err, "creating non-existent cache dir")
 		}
 	}
 	if !fs.IsDir(cacheDir) {
-		return errors.Errorf("%q is not a directory!", cacheDir)
+		return nil, errors.Errorf("%q is not a directory!", cacheDir)
 	}
 
-	if err := download(cacheDir, ms); err != nil {
-		return errors.Wrap(err, "downloading")
+	if err := db.Authenticate(root); err != nil {
+		return nil, errors.Wrap(err, "authenticating")
 	}
-
-	for _, m := range ms {
-		if err := install(root, m); err != nil {
-			return errors.Wrapf(err, "installing %v", m.Name)
+	if err := download(root, ms); err != nil {
+		return nil, errors.Wrap(err, "downloading")
+	}
+	for i := range locals {
+		m := &locals[i]
+		fn := filepath.Join(cacheDir, m.Pkg())
+		if err := copyFile(fn, m.File); err != nil {
+			return nil, errors.Wrapf(err, "copying %q to cache", m.File)
+		}
+		if err := store(cacheDir, fn, m); err != nil {
+			return nil, errors.Wrapf(err, "caching %v", m.Name)
 		}
 	}
-	return nil
+	return append(ms, locals...), nil
 }
 
-func download(cache string, ms pm.Metas) error {
-	// TODO (sm): concurrently fetch
-	for _, m := range ms {
-		resp, err := http.Get(m.URL())
+// Progress, if not nil, is the terminal download progress is drawn on.
+var Progress io.Writer
+
+// download fetches any of ms not already in the cache below root, from their
+// remotes or else the remotes' mirrors, setting their Digests.
+//
+// Packages whose index gives no digest are looked up in the cache by name
+// and version instead; install still checks their manifest signatures.
+func download(root string, ms pm.Metas) error {
+	var p *db.Progress
+	if Progress != nil && len(ms) > 0 {
+		p = db.NewProgress(Progress, 200*time.Millisecond)
+		defer p.Close()
+	}
+	cms, err := cachedMetas(root)
+	if err != nil {
+		return errors.Wrap(err, "reading cache")
+	}
+	for i := range ms {
+		if ms[i].Digest == "" {
+			ms[i].Digest = cachedDigest(cms, ms[i].Name, ms[i].Version)
+		}
+	}
+	cacheDir := filepath.Join(root, cache)
+	return db.Parallel(len(ms), func(i int) error {
+		m := &ms[i]
+		if m.Digest != "" && fs.Exists(cached(root, *m)) {
+			return nil
+		}
+		us, err := db.Mirrors(root, m.Remote)
 		if err != nil {
-			return errors.Wrap(err, "http get")
+			return errors.Wrap(err, "loading mirrors")
+		}
+		fn := filepath.Join(cacheDir, m.Pkg())
+		errs := db.Errors{}
+		for _, u := range us {
+			err = db.Download(u.String()+"/"+m.Pkg(), fn, p)
+			if err == nil {
+				break
+			}
+			errs = append(errs, err)
 		}
-		fn := filepath.Join(cache, m.Pkg())
-		f, err := os.Create(fn)
 		if err != nil {
-			return errors.Wrap(err, "creating")
+			return errors.Wrapf(errs, "fetching %v", m.Name)
 		}
-
-		if n, err := io.Copy(f, resp.Body); err != nil {
-			return errors.Wrapf(err, "copy %q to disk after %d bytes", m.URL(), n)
+		if err := store(cacheDir, fn, m); err != nil {
+			return errors.Wrapf(err, "caching %v", m.Name)
 		}
+		return nil
+	})
+}
 
-		if err := resp.Body.Close(); err != nil {
-			return errors.Wrap(err, "closing resp body")
-		}
+// store moves the .pkg fn into the cache under its digest, which must match
+// m's if known, and records it in m.
+func store(cache, fn string, m *pm.Meta) error {
+	d, err := Digest(fn)
+	if err != nil {
+		return errors.Wrap(err, "digest")
+	}
+	if m.Digest != "" && m.Digest != d {
+		os.Remove(fn)
+		return errors.Errorf("got digest %v, want %v", d, m.Digest)
+	}
+	m.Digest = d
+	if err := os.Rename(fn, filepath.Join(cache, d+".pkg")); err != nil {
+		return errors.Wrap(err, "rename")
 	}
 	return nil
 }
 
+// copyFile copies the contents of src to dst.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return errors.Wrap(err, "create")
+	}
+	if n, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return errors.Wrapf(err, "copy after %d bytes", n)
+	}
+	return out.Close()
+}
+
 func verifyManifestIntegrity(root string, m pm.Meta) error {
-	pn := filepath.Join(root, cache, m.Pkg())
+	pn := cached(root, m)
 	man, err := getReadCloser(pn, "manifest.sha256")
 	if err != nil {
 		return errors.Wrap(err, "getting manifest reader")
@@ -103,7 +249,11 @@ func verifyManifestIntegrity(root string, m pm.Meta) error {
 		return errors.Wrap(err, "getting manifest reader")
 	}
 
-	if err := keyring.Verify(root, man, sig); err != nil {
+	p, err := db.LoadPolicy(root, m.Remote)
+	if err != nil {
+		return errors.Wrap(err, "loading signature policy")
+	}
+	if err := keyring.VerifyPolicy(root, man, sig, p); err != nil {
 		return errors.Wrap(err, "verifying manifest")
 	}
 	if err := man.Close(); err != nil {
@@ -116,7 +266,7 @@ func verifyManifestIntegrity(root string, m pm.Meta) error {
 }
 
 func expandPkgContents(root string, m pm.Meta) error {
-	pn := filepath.Join(root, cache, m.Pkg())
+	pn := cached(root, m)
 	man, err := getReadCloser(pn, "manifest.sha256")
 	if err != nil {
 		return errors.Wrap(err, "getting manifest reader")
@@ -158,6 +308,18 @@ func expandPkgContents(root string, m pm.Meta) error {
 		}
 
 		if hdr.Name == "manifest.sha256" || hdr.Name == "manifest.sha256.asc" {
+			// kept, unchecked, to show who signed the package.
+			f, err := os.OpenFile(filepath.Join(ip, hdr.Name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return errors.Wrap(err, "open file in install dir")
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return errors.Wrapf(err, "copying %q", hdr.Name)
+			}
+			if err := f.Close(); err != nil {
+				return errors.Wrapf(err, "closing %v", hdr.Name)
+			}
 			continue
 		}
 
@@ -277,7 +439,7 @@ func expandRoot(root string, m pm.Meta) error {
 		cs[elems[1]] = elems[0]
 	}
 
-	pn := filepath.Join(root, cache, m.Pkg())
+	pn := cached(root, m)
 	tbz, err := getReadCloser(pn, "root.tar.bz2")
 	if err != nil {
 		return errors.Wrap(err, "getting root.tar.bz2 reader")
@@ -314,11 +476,11 @@ func expandRoot(root string, m pm.Meta) error {
 
 func install(root string, m pm.Meta) error {
 	defer func() {
-		cached := filepath.Join(root, cache, m.Pkg())
-		if !fs.Exists(cached) {
+		pn := cached(root, m)
+		if KeepCache || !fs.Exists(pn) {
 			return
 		}
-		if err := os.Remove(cached); err != nil {
+		if err := os.Remove(pn); err != nil {
 			log.Printf("cleaning up cache: %v", err)
 		}
 	}()
@@ -353,7 +515,7 @@ func install(root string, m pm.Meta) error {
 	}
 
 	if err := db.AddInstalled(root, m); err != nil {
-		return errors.Wrapf(err, "adding ", m.Name)
+		return errors.Wrapf(err, "adding %v", m.Name)
 	}
 	return nil
 }
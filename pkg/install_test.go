@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"mcquay.me/fs"
+	"mcquay.me/pm"
+	"mcquay.me/pm/db"
+	"mcquay.me/pm/internal/pmtest"
+	"mcquay.me/pm/keyring"
+)
+
+func TestDownloadOnly(t *testing.T) {
+	testDownloadOnly(t, true)
+}
+
+// TestDownloadOnlyNoDigest checks that packages from indexes without digests
+// are found in the cache by name and version.
+func TestDownloadOnlyNoDigest(t *testing.T) {
+	testDownloadOnly(t, false)
+}
+
+func testDownloadOnly(t *testing.T, digest bool) {
+	root, done := pmtest.Dir(t)
+	defer done()
+	srv, sdone := pmtest.Dir(t)
+	defer sdone()
+
+	if err := keyring.NewKeyPair(root, "a", "a@example.com", []byte(pmtest.Passphrase)); err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	pn := pmtest.SignedPkg(t, root, srv, "a@example.com", "foo", "1.0.0", Create)
+	d, err := Digest(pn)
+	if err != nil {
+		t.Fatalf("digest: %v", err)
+	}
+	if !digest {
+		d = ""
+	}
+	av := fmt.Sprintf(`{"foo": {"1.0.0": {"name": "foo", "version": "1.0.0", "description": "test", "digest": %q}}}`, d)
+	if err := ioutil.WriteFile(filepath.Join(srv, "available.json"), []byte(av), 0644); err != nil {
+		t.Fatalf("writing available: %v", err)
+	}
+
+	var mu sync.Mutex
+	fetched := 0
+	fh := http.FileServer(http.Dir(srv))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".pkg") {
+			mu.Lock()
+			fetched++
+			mu.Unlock()
+		}
+		fh.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	if err := db.AddRemotes(root, []string{ts.URL}); err != nil {
+		t.Fatalf("add remote: %v", err)
+	}
+	if err := db.Pull(root); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if err := Download(root, []string{"foo"}); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if got, want := fetched, 1; got != want {
+		t.Fatalf("fetches: got %v, want %v", got, want)
+	}
+	if installed, err := db.IsInstalled(root, pm.Meta{Name: "foo"}); err != nil || installed {
+		t.Fatalf("download only installed foo: %v", err)
+	}
+
+	// the package is installed from the cache, without the remote.
+	ts.Close()
+	if err := Install(root, []string{"foo"}); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if got, want := fetched, 1; got != want {
+		t.Fatalf("fetches: got %v, want %v", got, want)
+	}
+	if !fs.Exists(filepath.Join(root, "bin", "hi")) {
+		t.Fatalf("bin/hi not installed")
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/openpgp"
 	yaml "gopkg.in/yaml.v2"
 
 	"mcquay.me/fs"
@@ -44,8 +43,8 @@ func init() {
 	}
 }
 
-// Create traverses the contents of dir and emits a valid pkg, signed by id
-func Create(key *openpgp.Entity, dir string) error {
+// Create traverses the contents of dir and emits a valid pkg, signed by key.
+func Create(key keyring.Signer, dir string) error {
 	if !fs.Exists(dir) {
 		return fmt.Errorf("%q: doesn't exist", dir)
 	}
@@ -167,7 +166,7 @@ func Create(key *openpgp.Entity, dir string) error {
 	if err != nil {
 		return errors.Wrap(err, "opening manifest")
 	}
-	if err := keyring.Sign(key, mfi, sig); err != nil {
+	if err := key.Sign(mfi, sig); err != nil {
 		return errors.Wrap(err, "signing")
 	}
 
@@ -229,6 +228,37 @@ func Create(key *openpgp.Entity, dir string) error {
 	return nil
 }
 
+// ReadMeta returns the metadata stored in the .pkg file at pn.
+func ReadMeta(pn string) (pm.Meta, error) {
+	md := pm.Meta{}
+	b, err := readFile(pn, "meta.yaml")
+	if err != nil {
+		return md, errors.Wrap(err, "reading metadata file")
+	}
+	if err := yaml.Unmarshal(b, &md); err != nil {
+		return md, errors.Wrap(err, "decoding metadata file")
+	}
+	if _, err := md.Valid(); err != nil {
+		return md, errors.Wrap(err, "invalid metadata")
+	}
+	return md, nil
+}
+
+// Digest returns the hex encoded sha256 of the .pkg at pn, which identifies
+// it in the package cache.
+func Digest(pn string) (string, error) {
+	f, err := os.Open(pn)
+	if err != nil {
+		return "", errors.Wrap(err, "open")
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrap(err, "hashing")
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
 func clean(root string) error {
 	for _, f := range crypto {
 		path := filepath.Join(root, f)
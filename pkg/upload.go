@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+	"mcquay.me/pm/db"
+)
+
+// Upload publishes the .pkg at pn to the namespace of the pmd server at
+// remote, authenticating with token.
+func Upload(remote, pn, token string) error {
+	if !fs.Exists(pn) {
+		return fmt.Errorf("%q: doesn't exist", pn)
+	}
+	if _, err := ReadMeta(pn); err != nil {
+		return errors.Wrap(err, "reading metadata")
+	}
+	if err := checkManifest(pn); err != nil {
+		return errors.Wrap(err, "checking manifest")
+	}
+
+	f, err := os.Open(pn)
+	if err != nil {
+		return errors.Wrap(err, "opening pkg file")
+	}
+	defer f.Close()
+
+	u := strings.TrimSuffix(remote, "/") + "/upload"
+	req, err := http.NewRequest(http.MethodPost, u, f)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := db.Client().Do(req)
+	if err != nil {
+		return errors.Wrap(err, "http post")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+	"mcquay.me/pm"
+	"mcquay.me/pm/db"
+	"mcquay.me/pm/keyring"
+)
+
+// PolicyFile is the name of the optional file in a namespace directory that
+// holds the keyring.Policy packages must satisfy to be published there.
+const PolicyFile = "policy.json"
+
+// LoadPolicy returns the signature policy of the namespace directory dir, or
+// def if it has none.
+func LoadPolicy(dir string, def keyring.Policy) (keyring.Policy, error) {
+	fn := filepath.Join(dir, PolicyFile)
+	if !fs.Exists(fn) {
+		return def, nil
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return def, errors.Wrap(err, "open")
+	}
+	defer f.Close()
+	p := keyring.Policy{}
+	if err := json.NewDecoder(f).Decode(&p); err != nil {
+		return def, errors.Wrap(err, "decoding policy")
+	}
+	if _, err := p.Valid(); err != nil {
+		return def, errors.Wrapf(err, "invalid policy in %q", fn)
+	}
+	return p, nil
+}
+
+// Promote copies the package named by label (name or name@version) from the
+// namespace directory from to the namespace directory to, where it must
+// satisfy p.
+func Promote(root, from, to, label string, p keyring.Policy) (pm.Meta, error) {
+	a, _, err := Index(from)
+	if err != nil {
+		return pm.Meta{}, errors.Wrap(err, "indexing source namespace")
+	}
+	ms, err := a.Installable([]string{label})
+	if err != nil {
+		return pm.Meta{}, errors.Wrap(err, "finding package")
+	}
+	m := ms[0]
+
+	if !fs.Exists(to) {
+		if err := os.MkdirAll(to, 0755); err != nil {
+			return m, errors.Wrap(err, "creating namespace dir")
+		}
+	}
+
+	// copy next to the destination first, as Publish links into place.
+	src, err := os.Open(filepath.Join(from, m.Pkg()))
+	if err != nil {
+		return m, errors.Wrap(err, "opening pkg file")
+	}
+	defer src.Close()
+	tf, err := ioutil.TempFile(to, ".promote-")
+	if err != nil {
+		return m, errors.Wrap(err, "creating temporary pkg")
+	}
+	defer os.Remove(tf.Name())
+	if n, err := io.Copy(tf, src); err != nil {
+		tf.Close()
+		return m, errors.Wrapf(err, "copy %v after %d bytes", m.Pkg(), n)
+	}
+	if err := tf.Close(); err != nil {
+		return m, errors.Wrap(err, "closing temporary pkg")
+	}
+	if err := os.Chmod(tf.Name(), 0644); err != nil {
+		return m, errors.Wrap(err, "chmod temporary pkg")
+	}
+
+	return Publish(root, to, tf.Name(), p)
+}
+
+// PromoteRemote asks the pmd server hosting the namespaces at the urls from
+// and to to promote the package named by label, authenticating with token.
+func PromoteRemote(from, to, label, token string) error {
+	fu, err := url.Parse(from)
+	if err != nil {
+		return errors.Wrap(err, "url parse")
+	}
+	tu, err := url.Parse(to)
+	if err != nil {
+		return errors.Wrap(err, "url parse")
+	}
+	if fu.Scheme != tu.Scheme || fu.Host != tu.Host {
+		return errors.Errorf("%q and %q are not served by the same pmd", from, to)
+	}
+
+	q := url.Values{}
+	q.Set("from", fu.Path)
+	q.Set("pkg", label)
+	u := strings.TrimSuffix(to, "/") + "/promote?" + q.Encode()
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := db.Client().Do(req)
+	if err != nil {
+		return errors.Wrap(err, "http post")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
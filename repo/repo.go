@@ -0,0 +1,208 @@
+// Package repo manages directories of .pkg files served by pmd.
+//
+// A repository is a directory tree whose leaves are namespaces, e.g.
+// linux/amd64/stable, each containing .pkg files named <name>-<version>.pkg.
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+	"mcquay.me/pm"
+	"mcquay.me/pm/keyring"
+	"mcquay.me/pm/pkg"
+)
+
+// ErrExists is returned when publishing a name@version that a namespace
+// already contains.
+var ErrExists = errors.New("package already exists")
+
+// Skip records a package left out of an index, and why.
+type Skip struct {
+	File string
+	Err  error
+}
+
+func (s Skip) String() string {
+	return fmt.Sprintf("%v: %v", s.File, s.Err)
+}
+
+// Index returns the available packages found in the namespace directory dir,
+// and those that were skipped because their metadata is missing or invalid.
+func Index(dir string) (pm.Available, []Skip, error) {
+	return index(dir, func(string) error { return nil })
+}
+
+// VerifiedIndex is like Index, but also skips packages whose contents or
+// signatures, checked against p using the keyring below root, are invalid.
+func VerifiedIndex(root, dir string, p keyring.Policy) (pm.Available, []Skip, error) {
+	return index(dir, func(pn string) error {
+		return pkg.Verify(root, pn, p)
+	})
+}
+
+func index(dir string, check func(pn string) error) (pm.Available, []Skip, error) {
+	r := pm.Available{}
+	skips := []Skip{}
+	if !fs.IsDir(dir) {
+		return r, nil, errors.Errorf("%q is not a directory", dir)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return r, nil, errors.Wrap(err, "reading namespace dir")
+	}
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".pkg") {
+			continue
+		}
+		pn := filepath.Join(dir, fi.Name())
+		m, err := pkg.ReadMeta(pn)
+		if err != nil {
+			skips = append(skips, Skip{fi.Name(), err})
+			continue
+		}
+		if m.Pkg() != fi.Name() {
+			skips = append(skips, Skip{fi.Name(), errors.Errorf("should be named %q", m.Pkg())})
+			continue
+		}
+		if err := check(pn); err != nil {
+			skips = append(skips, Skip{fi.Name(), err})
+			continue
+		}
+		if m.Digest, err = digest(pn, fi); err != nil {
+			skips = append(skips, Skip{fi.Name(), err})
+			continue
+		}
+		if err := r.Add(m); err != nil {
+			skips = append(skips, Skip{fi.Name(), err})
+		}
+	}
+	return r, skips, nil
+}
+
+// digests memoizes the digests of .pkg files by path, as servers index
+// namespaces on every request.
+var digests = struct {
+	sync.Mutex
+	m map[string]digested
+}{m: map[string]digested{}}
+
+type digested struct {
+	size   int64
+	mod    time.Time
+	digest string
+}
+
+// digest returns the digest of the .pkg at pn, whose current FileInfo is fi.
+func digest(pn string, fi os.FileInfo) (string, error) {
+	digests.Lock()
+	d, ok := digests.m[pn]
+	digests.Unlock()
+	if ok && d.size == fi.Size() && d.mod.Equal(fi.ModTime()) {
+		return d.digest, nil
+	}
+	sum, err := pkg.Digest(pn)
+	if err != nil {
+		return "", err
+	}
+	digests.Lock()
+	digests.m[pn] = digested{fi.Size(), fi.ModTime(), sum}
+	digests.Unlock()
+	return sum, nil
+}
+
+// WriteIndex atomically writes a as the available.json of the namespace
+// directory dir. If key is not nil a detached signature of it is written to
+// available.json.asc.
+//
+// Nothing is written unless signing succeeds, and the index is written before
+// its signature.
+func WriteIndex(dir string, a pm.Available, key keyring.Signer) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(&a); err != nil {
+		return errors.Wrap(err, "encoding available")
+	}
+	sig := &bytes.Buffer{}
+	if key != nil {
+		if err := key.Sign(bytes.NewReader(buf.Bytes()), sig); err != nil {
+			return errors.Wrap(err, "signing available")
+		}
+	}
+	if err := writeFile(filepath.Join(dir, "available.json"), buf.Bytes()); err != nil {
+		return errors.Wrap(err, "writing available")
+	}
+	if key != nil {
+		if err := writeFile(filepath.Join(dir, "available.json.asc"), sig.Bytes()); err != nil {
+			return errors.Wrap(err, "writing signature")
+		}
+	}
+	return nil
+}
+
+// writeFile atomically replaces the contents of fn with b.
+func writeFile(fn string, b []byte) error {
+	dir, base := filepath.Split(fn)
+	tf, err := ioutil.TempFile(dir, "."+base+"-")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	if _, err := tf.Write(b); err != nil {
+		tf.Close()
+		os.Remove(tf.Name())
+		return errors.Wrap(err, "writing temporary file")
+	}
+	if err := tf.Close(); err != nil {
+		os.Remove(tf.Name())
+		return errors.Wrap(err, "closing temporary file")
+	}
+	if err := os.Chmod(tf.Name(), 0644); err != nil {
+		os.Remove(tf.Name())
+		return errors.Wrap(err, "chmod temporary file")
+	}
+	if err := os.Rename(tf.Name(), fn); err != nil {
+		os.Remove(tf.Name())
+		return errors.Wrap(err, "renaming temporary file")
+	}
+	return nil
+}
+
+// Publish verifies the .pkg at pn against p, using the keyring below root,
+// and adds it to the namespace directory dir.
+//
+// The package is linked into place, so pn must be on the same filesystem as
+// dir; it appears in the namespace all at once, or not at all.
+func Publish(root, dir, pn string, p keyring.Policy) (pm.Meta, error) {
+	m, err := pkg.ReadMeta(pn)
+	if err != nil {
+		return m, errors.Wrap(err, "reading metadata")
+	}
+	if err := pkg.Verify(root, pn, p); err != nil {
+		return m, errors.Wrap(err, "verifying package")
+	}
+	if filepath.Dir(filepath.Join(dir, m.Pkg())) != filepath.Clean(dir) {
+		return m, errors.Errorf("invalid package name %q", m.Name)
+	}
+	if !fs.Exists(dir) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return m, errors.Wrap(err, "creating namespace dir")
+		}
+	}
+	if err := os.Link(pn, filepath.Join(dir, m.Pkg())); err != nil {
+		if os.IsExist(err) {
+			return m, errors.Wrapf(ErrExists, "%v@%v", m.Name, m.Version)
+		}
+		return m, errors.Wrap(err, "linking package into namespace")
+	}
+	return m, nil
+}
@@ -0,0 +1,456 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mcquay.me/pm"
+	"mcquay.me/pm/internal/pmtest"
+	"mcquay.me/pm/keyring"
+	"mcquay.me/pm/pkg"
+)
+
+func TestIndex(t *testing.T) {
+	dir, done := pmtest.Dir(t)
+	defer done()
+
+	ns := filepath.Join(dir, "linux", "amd64", "stable")
+	pmtest.FakePkg(t, ns, "foo", "1.0.0")
+	pmtest.FakePkg(t, ns, "foo", "1.1.0")
+	pmtest.FakePkg(t, ns, "bar", "0.1.0")
+
+	a, skips, err := Index(ns)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if got, want := len(skips), 0; got != want {
+		t.Fatalf("skips: got %v, want %v", got, want)
+	}
+	if got, want := len(a), 2; got != want {
+		t.Fatalf("names: got %v, want %v", got, want)
+	}
+	if got, want := len(a["foo"]), 2; got != want {
+		t.Fatalf("foo versions: got %v, want %v", got, want)
+	}
+	want, err := pkg.Digest(filepath.Join(ns, "bar-0.1.0.pkg"))
+	if err != nil {
+		t.Fatalf("digest: %v", err)
+	}
+	if got := a["bar"]["0.1.0"].Digest; got != want {
+		t.Fatalf("digest: got %q, want %q", got, want)
+	}
+
+	if err := os.Rename(filepath.Join(ns, "bar-0.1.0.pkg"), filepath.Join(ns, "bar.pkg")); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	a, skips, err = Index(ns)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if got, want := len(skips), 1; got != want {
+		t.Fatalf("skips: got %v, want %v", got, want)
+	}
+	if _, ok := a["bar"]; ok {
+		t.Fatalf("misnamed package was indexed")
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir, done := pmtest.Dir(t)
+	defer done()
+	root, rdone := pmtest.Dir(t)
+	defer rdone()
+
+	pmtest.FakePkg(t, filepath.Join(dir, "linux", "amd64", "stable"), "foo", "1.0.0")
+
+	ts := httptest.NewServer(NewServer(dir, root))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/linux/amd64/stable/available.json")
+	if err != nil {
+		t.Fatalf("get available: %v", err)
+	}
+	a := pm.Available{}
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		t.Fatalf("decode available: %v", err)
+	}
+	resp.Body.Close()
+	m, err := a.Get("foo", "")
+	if err != nil {
+		t.Fatalf("get foo: %v", err)
+	}
+
+	u := ts.URL + "/linux/amd64/stable/" + m.Pkg()
+	resp, err = http.Get(u)
+	if err != nil {
+		t.Fatalf("get pkg: %v", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("get %v: got %v, want %v", u, got, want)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/linux/amd64/stable/keys", want: http.StatusOK},
+		{path: "/linux/amd64/testing/available.json", want: http.StatusNotFound},
+		{path: "/linux/amd64/stable/available.json.asc", want: http.StatusNotFound},
+		{path: "/linux/amd64/stable/bar-1.0.0.pkg", want: http.StatusNotFound},
+		{path: "/linux/amd64/stable/meta.yaml", want: http.StatusNotFound},
+		{path: "/../../etc/passwd.pkg", want: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(ts.URL + test.path)
+		if err != nil {
+			t.Fatalf("get %v: %v", test.path, err)
+		}
+		resp.Body.Close()
+		if got := resp.StatusCode; got != test.want {
+			t.Fatalf("%v: got %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestAvailableConditional(t *testing.T) {
+	dir, done := pmtest.Dir(t)
+	defer done()
+	root, rdone := pmtest.Dir(t)
+	defer rdone()
+
+	ns := filepath.Join(dir, "linux", "amd64", "stable")
+	pmtest.FakePkg(t, ns, "foo", "1.0.0")
+	ts := httptest.NewServer(NewServer(dir, root))
+	defer ts.Close()
+	u := ts.URL + "/linux/amd64/stable/available.json"
+
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("get available: %v", err)
+	}
+	resp.Body.Close()
+	if !resp.Uncompressed {
+		t.Fatalf("available was not compressed")
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("missing etag")
+	}
+
+	get := func() int {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		req.Header.Set("If-None-Match", etag)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get available: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got, want := get(), http.StatusNotModified; got != want {
+		t.Fatalf("unchanged: got %v, want %v", got, want)
+	}
+	pmtest.FakePkg(t, ns, "foo", "1.1.0")
+	if got, want := get(), http.StatusOK; got != want {
+		t.Fatalf("changed: got %v, want %v", got, want)
+	}
+}
+
+func TestSignedAvailable(t *testing.T) {
+	dir, done := pmtest.Dir(t)
+	defer done()
+	root, rdone := pmtest.Dir(t)
+	defer rdone()
+
+	if err := keyring.NewEd25519KeyPair(root, "a", "a@example.com", []byte(pmtest.Passphrase)); err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	pmtest.FakePkg(t, filepath.Join(dir, "linux", "amd64", "stable"), "foo", "1.0.0")
+	s := NewServer(dir, root)
+	key := pmtest.Signer(t, root, "a@example.com")
+	s.Key = key
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	get := func(fn string) []byte {
+		resp, err := http.Get(ts.URL + "/linux/amd64/stable/" + fn)
+		if err != nil {
+			t.Fatalf("get %v: %v", fn, err)
+		}
+		defer resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("get %v: got %v, want %v", fn, got, want)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read %v: %v", fn, err)
+		}
+		return b
+	}
+	for i := 0; i < 2; i++ {
+		av, sig := get("available.json"), get("available.json.asc")
+		if err := keyring.Verify(root, bytes.NewReader(av), bytes.NewReader(sig)); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+		pmtest.FakePkg(t, filepath.Join(dir, "linux", "amd64", "stable"), "foo", "1.1.0")
+	}
+}
+
+func TestUpload(t *testing.T) {
+	dir, done := pmtest.Dir(t)
+	defer done()
+	root, rdone := pmtest.Dir(t)
+	defer rdone()
+	work, wdone := pmtest.Dir(t)
+	defer wdone()
+
+	if err := keyring.NewKeyPair(root, "a", "a@example.com", []byte(pmtest.Passphrase)); err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	good := pmtest.SignedPkg(t, root, work, "a@example.com", "foo", "1.0.0", pkg.Create)
+	unsigned := pmtest.FakePkg(t, work, "bar", "1.0.0")
+
+	s := NewServer(dir, root)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	remote := ts.URL + "/linux/amd64/stable"
+
+	if err := pkg.Upload(remote, good, "secret"); err == nil {
+		t.Fatalf("upload succeeded with uploads disabled")
+	}
+	s.Token = "secret"
+	if err := pkg.Upload(remote, good, "wrong"); err == nil {
+		t.Fatalf("upload succeeded with wrong token")
+	}
+	if err := pkg.Upload(remote, good, "secret"); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if err := pkg.Upload(remote, good, "secret"); err == nil {
+		t.Fatalf("duplicate upload succeeded")
+	}
+	if err := pkg.Upload(remote, unsigned, "secret"); err == nil {
+		t.Fatalf("unsigned upload succeeded")
+	}
+	escape := pmtest.FakePkg(t, work, "..", "1.0.0")
+	if _, err := Publish(root, filepath.Join(dir, "linux", "amd64", "stable"), escape, keyring.Policy{Threshold: 1}); err == nil || !strings.Contains(err.Error(), "name") {
+		t.Fatalf("published package named ..: %v", err)
+	}
+
+	a, _, err := Index(filepath.Join(dir, "linux", "amd64", "stable"))
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if _, err := a.Get("foo", "1.0.0"); err != nil {
+		t.Fatalf("uploaded package missing: %v", err)
+	}
+	if got, want := len(a), 1; got != want {
+		t.Fatalf("packages: got %v, want %v", got, want)
+	}
+
+	s.Publishers = keyring.Policy{Threshold: 1, Keys: []string{"0000000000000000000000000000000000000000"}}
+	other := pmtest.SignedPkg(t, root, work, "a@example.com", "foo", "1.1.0", pkg.Create)
+	if err := pkg.Upload(remote, other, "secret"); err == nil {
+		t.Fatalf("upload by non-publisher succeeded")
+	}
+}
+
+func TestVerifiedIndex(t *testing.T) {
+	dir, done := pmtest.Dir(t)
+	defer done()
+	root, rdone := pmtest.Dir(t)
+	defer rdone()
+
+	if err := keyring.NewKeyPair(root, "a", "a@example.com", []byte(pmtest.Passphrase)); err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	pmtest.SignedPkg(t, root, dir, "a@example.com", "foo", "1.0.0", pkg.Create)
+	pmtest.FakePkg(t, dir, "bar", "1.0.0")
+
+	a, skips, err := VerifiedIndex(root, dir, keyring.Policy{Threshold: 1})
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if got, want := len(skips), 1; got != want {
+		t.Fatalf("skips: got %v, want %v", got, want)
+	}
+	if got, want := skips[0].File, "bar-1.0.0.pkg"; got != want {
+		t.Fatalf("skipped: got %v, want %v", got, want)
+	}
+
+	s := pmtest.Signer(t, root, "a@example.com")
+	if err := WriteIndex(dir, a, s); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "available.json"))
+	if err != nil {
+		t.Fatalf("open available: %v", err)
+	}
+	defer f.Close()
+	sig, err := os.Open(filepath.Join(dir, "available.json.asc"))
+	if err != nil {
+		t.Fatalf("open signature: %v", err)
+	}
+	defer sig.Close()
+	if err := keyring.Verify(root, f, sig); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	f.Seek(0, 0)
+	got := pm.Available{}
+	if err := json.NewDecoder(f).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if _, err := got.Get("foo", "1.0.0"); err != nil {
+		t.Fatalf("get foo: %v", err)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	dir, done := pmtest.Dir(t)
+	defer done()
+	root, rdone := pmtest.Dir(t)
+	defer rdone()
+
+	for _, id := range []string{"a", "b"} {
+		if err := keyring.NewKeyPair(root, id, id+"@example.com", []byte(pmtest.Passphrase)); err != nil {
+			t.Fatalf("new key: %v", err)
+		}
+	}
+	tns := filepath.Join(dir, "linux", "amd64", "testing")
+	sns := filepath.Join(dir, "linux", "amd64", "stable")
+	pn := pmtest.SignedPkg(t, root, tns, "a@example.com", "foo", "1.0.0", pkg.Create)
+	if err := os.MkdirAll(sns, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(sns, PolicyFile), []byte(`{"threshold": 2}`), 0644); err != nil {
+		t.Fatalf("writing policy: %v", err)
+	}
+
+	s := NewServer(dir, root)
+	s.Token = "secret"
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	from, to := ts.URL+"/linux/amd64/testing", ts.URL+"/linux/amd64/stable"
+
+	if err := PromoteRemote(from, to, "foo@1.0.0", "secret"); err == nil {
+		t.Fatalf("promoted without second signature")
+	}
+
+	b := pmtest.Signer(t, root, "b@example.com")
+	if err := pkg.Cosign(b, pn); err != nil {
+		t.Fatalf("cosign: %v", err)
+	}
+	if err := PromoteRemote(from, to, "foo@1.0.0", "wrong"); err == nil {
+		t.Fatalf("promoted with wrong token")
+	}
+	if err := PromoteRemote(from, to, "foo@1.0.0", "secret"); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	if err := PromoteRemote(from, to, "foo", "secret"); err == nil {
+		t.Fatalf("promoted twice")
+	}
+
+	a, _, err := Index(sns)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if _, err := a.Get("foo", "1.0.0"); err != nil {
+		t.Fatalf("promoted package missing: %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir, done := pmtest.Dir(t)
+	defer done()
+
+	for _, v := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0"} {
+		pmtest.FakePkg(t, dir, "foo", v)
+	}
+	pmtest.FakePkg(t, dir, "bar", "0.1.0")
+	now := time.Now()
+	old := now.Add(-60 * 24 * time.Hour)
+	for _, v := range []string{"1.0.0", "1.1.0"} {
+		if err := os.Chtimes(filepath.Join(dir, "foo-"+v+".pkg"), old, old); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(dir, "bar-0.1.0.pkg"), old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	tests := []struct {
+		label string
+		r     Retention
+		want  []string
+	}{
+		{label: "keep", r: Retention{Keep: 2}, want: []string{"foo@1.1.0", "foo@1.0.0"}},
+		{label: "keep pinned", r: Retention{Keep: 2, Pins: []string{"foo@1.0.0"}}, want: []string{"foo@1.1.0"}},
+		{label: "age", r: Retention{MaxAgeDays: 30}, want: []string{"foo@1.1.0", "foo@1.0.0"}},
+		{label: "keep all", r: Retention{Keep: 10}, want: []string{}},
+	}
+	for _, test := range tests {
+		ms, err := Prune(dir, test.r, now, true)
+		if err != nil {
+			t.Fatalf("%v: prune: %v", test.label, err)
+		}
+		got := []string{}
+		for _, m := range ms {
+			got = append(got, string(m.Name)+"@"+string(m.Version))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%v: got %v, want %v", test.label, got, test.want)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, RetentionFile), []byte(`{"keep": 3}`), 0644); err != nil {
+		t.Fatalf("writing retention: %v", err)
+	}
+	pruned, err := PruneTree(dir, now)
+	if err != nil {
+		t.Fatalf("prune tree: %v", err)
+	}
+	if got, want := len(pruned[dir]), 1; got != want {
+		t.Fatalf("pruned: got %v, want %v", got, want)
+	}
+	a, _, err := Index(dir)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if _, err := a.Get("foo", "1.0.0"); err == nil {
+		t.Fatalf("foo@1.0.0 not removed")
+	}
+	if got, want := len(a["foo"]), 3; got != want {
+		t.Fatalf("foo versions: got %v, want %v", got, want)
+	}
+
+	// versions are ranked numerically, not as strings.
+	num, ndone := pmtest.Dir(t)
+	defer ndone()
+	for _, v := range []string{"1.9.0", "1.10.0"} {
+		pmtest.FakePkg(t, num, "foo", v)
+	}
+	ms, err := Prune(num, Retention{Keep: 1}, now, false)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if len(ms) != 1 || ms[0].Version != "1.9.0" {
+		t.Fatalf("pruned: got %v, want foo@1.9.0", ms)
+	}
+	if _, err := os.Stat(filepath.Join(num, "foo-1.10.0.pkg")); err != nil {
+		t.Fatalf("newest version removed")
+	}
+}
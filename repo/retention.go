@@ -0,0 +1,161 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+	"mcquay.me/pm"
+)
+
+// RetentionFile is the name of the optional file in a namespace directory
+// that holds its Retention policy.
+const RetentionFile = "retention.json"
+
+// Retention describes which packages Prune keeps in a namespace.
+//
+// A version is pruned if it is not among the newest Keep versions of its
+// package, as ordered by pm.Version.Compare, or was published more than
+// MaxAgeDays ago. The newest version of
+// each package, and versions listed in Pins, are always kept.
+type Retention struct {
+	// Keep, if not zero, is the number of versions of each package to keep.
+	Keep int `json:"keep,omitempty"`
+
+	// MaxAgeDays, if not zero, is the age after which versions are pruned.
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+
+	// Pins lists name@version labels that are never pruned. They are
+	// maintained by the repository's operator: a repository cannot see what
+	// its clients pin or hold, so versions they need must be listed here.
+	Pins []string `json:"pins,omitempty"`
+}
+
+// Valid validates the contents of a Retention.
+func (r Retention) Valid() (bool, error) {
+	if r.Keep < 0 {
+		return false, errors.New("keep cannot be negative")
+	}
+	if r.MaxAgeDays < 0 {
+		return false, errors.New("max_age_days cannot be negative")
+	}
+	if r.Keep == 0 && r.MaxAgeDays == 0 {
+		return false, errors.New("one of keep or max_age_days must be set")
+	}
+	return true, nil
+}
+
+// LoadRetention returns the Retention of the namespace directory dir, and
+// whether it has one.
+func LoadRetention(dir string) (Retention, bool, error) {
+	r := Retention{}
+	fn := filepath.Join(dir, RetentionFile)
+	if !fs.Exists(fn) {
+		return r, false, nil
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return r, false, errors.Wrap(err, "open")
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return r, false, errors.Wrap(err, "decoding retention")
+	}
+	if _, err := r.Valid(); err != nil {
+		return r, false, errors.Wrapf(err, "invalid retention in %q", fn)
+	}
+	return r, true, nil
+}
+
+// Prune removes the packages in the namespace directory dir that r does not
+// keep, as of now, and returns them. If dryRun is true nothing is removed.
+func Prune(dir string, r Retention, now time.Time, dryRun bool) (pm.Metas, error) {
+	if _, err := r.Valid(); err != nil {
+		return nil, errors.Wrap(err, "invalid retention")
+	}
+	a, _, err := Index(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "indexing namespace")
+	}
+
+	pins := map[string]bool{}
+	for _, p := range r.Pins {
+		pins[p] = true
+	}
+	cutoff := now.Add(-time.Duration(r.MaxAgeDays) * 24 * time.Hour)
+
+	names := pm.Names{}
+	for n := range a {
+		names = append(names, n)
+	}
+	sort.Sort(names)
+
+	pruned := pm.Metas{}
+	for _, n := range names {
+		vers := pm.Versions{}
+		for v := range a[n] {
+			vers = append(vers, v)
+		}
+		sort.Sort(sort.Reverse(vers))
+		for i, v := range vers {
+			m := a[n][v]
+			if i == 0 || pins[fmt.Sprintf("%v@%v", n, v)] {
+				continue
+			}
+			old := false
+			if r.MaxAgeDays > 0 {
+				fi, err := os.Stat(filepath.Join(dir, m.Pkg()))
+				if err != nil {
+					return pruned, errors.Wrap(err, "stat")
+				}
+				old = fi.ModTime().Before(cutoff)
+			}
+			if (r.Keep > 0 && i >= r.Keep) || old {
+				pruned = append(pruned, m)
+			}
+		}
+	}
+
+	if dryRun {
+		return pruned, nil
+	}
+	for _, m := range pruned {
+		if err := os.Remove(filepath.Join(dir, m.Pkg())); err != nil {
+			return pruned, errors.Wrapf(err, "removing %v", m.Pkg())
+		}
+	}
+	return pruned, nil
+}
+
+// PruneTree prunes every namespace below dir that has a RetentionFile,
+// returning the removed packages keyed by namespace directory.
+func PruneTree(dir string, now time.Time) (map[string]pm.Metas, error) {
+	r := map[string]pm.Metas{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != RetentionFile {
+			return nil
+		}
+		ns := filepath.Dir(path)
+		ret, _, err := LoadRetention(ns)
+		if err != nil {
+			return errors.Wrapf(err, "loading retention for %q", ns)
+		}
+		ms, err := Prune(ns, ret, now, false)
+		if len(ms) > 0 {
+			r[ns] = ms
+		}
+		if err != nil {
+			return errors.Wrapf(err, "pruning %q", ns)
+		}
+		return nil
+	})
+	return r, err
+}
@@ -0,0 +1,317 @@
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"mcquay.me/fs"
+	"mcquay.me/pm/keyring"
+)
+
+// maxUpload is the largest .pkg accepted by the upload endpoint.
+const maxUpload = 1 << 30
+
+// Server serves the namespaces found below a repository directory, and the
+// public keys of a keyring.
+//
+// For a namespace such as /linux/amd64/stable it serves:
+//
+//   /linux/amd64/stable/available.json  -- the namespace's pm.Available
+//   /linux/amd64/stable/available.json.asc  -- its signature, if Key is set
+//   /linux/amd64/stable/<name>-<version>.pkg
+//   /linux/amd64/stable/keys            -- the repository's public keys
+//
+// If Token is set, packages may also be POSTed to
+// /linux/amd64/stable/upload, and copied from another namespace with a POST
+// to /linux/amd64/stable/promote?from=/linux/amd64/testing&pkg=name@version.
+// Either must satisfy the namespace's PolicyFile, if it has one, or else
+// Publishers.
+type Server struct {
+	// Token, if not empty, enables uploads by clients presenting it as a
+	// bearer token.
+	Token string
+
+	// Publishers is the signature policy uploaded packages must satisfy
+	// using the keyring below root.
+	Publishers keyring.Policy
+
+	// Key, if not nil, signs the available.json of every namespace.
+	Key keyring.Signer
+
+	dir  string
+	root string
+
+	// sig caches the signature of the last index signed.
+	sig struct {
+		sync.Mutex
+		sum [sha256.Size]byte
+		b   []byte
+	}
+}
+
+// NewServer returns a Server for the repository at dir, exposing the public
+// keys in the keyring below root.
+func NewServer(dir, root string) *Server {
+	return &Server{
+		Publishers: keyring.Policy{Threshold: 1},
+		dir:        dir,
+		root:       root,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + r.URL.Path)
+	if r.Method == http.MethodPost {
+		switch path.Base(p) {
+		case "upload":
+			s.upload(w, r, path.Dir(p))
+			return
+		case "promote":
+			s.promote(w, r, path.Dir(p))
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case path.Base(p) == "keys":
+		s.keys(w, r)
+	case path.Base(p) == "available.json":
+		s.available(w, r, path.Dir(p))
+	case path.Base(p) == "available.json.asc" && s.Key != nil:
+		s.signature(w, r, path.Dir(p))
+	case strings.HasSuffix(p, ".pkg"):
+		fn := s.path(p)
+		if !fs.Exists(fn) || fs.IsDir(fn) {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, fn)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) keys(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	if err := keyring.ExportAll(s.root, buf); err != nil {
+		log.Printf("exporting keys: %v", err)
+		http.Error(w, "exporting keys", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// index returns the encoded available.json of the namespace ns, or writes
+// an error to w and returns nil.
+func (s *Server) index(w http.ResponseWriter, r *http.Request, ns string) []byte {
+	dir := s.path(ns)
+	if !fs.IsDir(dir) {
+		http.NotFound(w, r)
+		return nil
+	}
+	a, skips, err := Index(dir)
+	if err != nil {
+		log.Printf("indexing %q: %v", ns, err)
+		http.Error(w, "indexing namespace", http.StatusInternalServerError)
+		return nil
+	}
+	for _, sk := range skips {
+		log.Printf("indexing %q: skipped %v", ns, sk)
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(&a); err != nil {
+		log.Printf("encoding available for %q: %v", ns, err)
+		http.Error(w, "encoding available", http.StatusInternalServerError)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (s *Server) available(w http.ResponseWriter, r *http.Request, ns string) {
+	b := s.index(w, r, ns)
+	if b == nil {
+		return
+	}
+
+	// the index is weakly validated as it is served both plain and gzipped.
+	etag := fmt.Sprintf(`W/"%x"`, sha256.Sum256(b))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept-Encoding")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Write(b)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	gz.Write(b)
+	if err := gz.Close(); err != nil {
+		log.Printf("compressing available for %q: %v", ns, err)
+	}
+}
+
+// signature serves a detached signature of the available.json of the
+// namespace ns, made by Key.
+func (s *Server) signature(w http.ResponseWriter, r *http.Request, ns string) {
+	b := s.index(w, r, ns)
+	if b == nil {
+		return
+	}
+	sum := sha256.Sum256(b)
+	s.sig.Lock()
+	defer s.sig.Unlock()
+	if s.sig.b == nil || s.sig.sum != sum {
+		sig := &bytes.Buffer{}
+		if err := s.Key.Sign(bytes.NewReader(b), sig); err != nil {
+			log.Printf("signing available for %q: %v", ns, err)
+			http.Error(w, "signing available", http.StatusInternalServerError)
+			return
+		}
+		s.sig.sum, s.sig.b = sum, sig.Bytes()
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(s.sig.b)
+}
+
+// authorized reports if r carries the upload token, writing an error to w if
+// not.
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if s.Token == "" {
+		http.Error(w, "uploads not enabled", http.StatusForbidden)
+		return false
+	}
+	tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(tok), []byte(s.Token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request, ns string) {
+	if !s.authorized(w, r) {
+		return
+	}
+
+	dir := s.path(ns)
+	if !fs.Exists(dir) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Printf("creating namespace %q: %v", ns, err)
+			http.Error(w, "creating namespace", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tf, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		log.Printf("creating upload file: %v", err)
+		http.Error(w, "storing upload", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tf.Name())
+	if _, err := io.Copy(tf, http.MaxBytesReader(w, r.Body, maxUpload)); err != nil {
+		tf.Close()
+		http.Error(w, fmt.Sprintf("reading upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := tf.Close(); err != nil {
+		log.Printf("closing upload file: %v", err)
+		http.Error(w, "storing upload", http.StatusInternalServerError)
+		return
+	}
+	if err := os.Chmod(tf.Name(), 0644); err != nil {
+		log.Printf("chmod upload file: %v", err)
+		http.Error(w, "storing upload", http.StatusInternalServerError)
+		return
+	}
+
+	p, err := LoadPolicy(dir, s.Publishers)
+	if err != nil {
+		log.Printf("loading policy for %q: %v", ns, err)
+		http.Error(w, "loading namespace policy", http.StatusInternalServerError)
+		return
+	}
+	m, err := Publish(s.root, dir, tf.Name(), p)
+	if errors.Cause(err) == ErrExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("published %v@%v to %q", m.Name, m.Version, ns)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%v\n", path.Join(ns, m.Pkg()))
+}
+
+func (s *Server) promote(w http.ResponseWriter, r *http.Request, ns string) {
+	if !s.authorized(w, r) {
+		return
+	}
+	from := path.Clean("/" + r.URL.Query().Get("from"))
+	label := r.URL.Query().Get("pkg")
+	if label == "" {
+		http.Error(w, "missing pkg", http.StatusBadRequest)
+		return
+	}
+	if from == ns {
+		http.Error(w, "cannot promote within a namespace", http.StatusBadRequest)
+		return
+	}
+	fdir := s.path(from)
+	if !fs.IsDir(fdir) {
+		http.Error(w, fmt.Sprintf("namespace %q not found", from), http.StatusNotFound)
+		return
+	}
+
+	dir := s.path(ns)
+	p, err := LoadPolicy(dir, s.Publishers)
+	if err != nil {
+		log.Printf("loading policy for %q: %v", ns, err)
+		http.Error(w, "loading namespace policy", http.StatusInternalServerError)
+		return
+	}
+	m, err := Promote(s.root, fdir, dir, label, p)
+	if errors.Cause(err) == ErrExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("promoted %v@%v from %q to %q", m.Name, m.Version, from, ns)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%v\n", path.Join(ns, m.Pkg()))
+}
+
+// path returns the location on disk of the cleaned url path p.
+func (s *Server) path(p string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(p))
+}
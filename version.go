@@ -0,0 +1,71 @@
+package pm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Compare returns -1, 0, or 1 as v is older than, the same as, or newer than
+// o.
+//
+// Versions are compared a dot-separated field at a time: numerically where
+// both fields are numbers, so that 1.10.0 is newer than 1.9.0, and otherwise
+// as strings, numbers sorting first. A pre-release, e.g. 2.0.0-rc1, is older
+// than the release it precedes.
+func (v Version) Compare(o Version) int {
+	vr, vp := splitPre(string(v))
+	or, op := splitPre(string(o))
+	if c := compareFields(vr, or); c != 0 {
+		return c
+	}
+	switch {
+	case vp == op:
+		return 0
+	case vp == "":
+		return 1
+	case op == "":
+		return -1
+	}
+	return compareFields(vp, op)
+}
+
+// splitPre splits a version into its release and pre-release parts.
+func splitPre(s string) (string, string) {
+	if i := strings.Index(s, "-"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func compareFields(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package pm
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b Version
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.9.0", "1.10.0", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "10.0.0", -1},
+		{"1.0", "1.0.1", -1},
+		{"2.0.0-rc1", "2.0.0", -1},
+		{"2.0.0-rc2", "2.0.0-rc10", 1},
+		{"2.0.0-beta.2", "2.0.0-beta.10", -1},
+		{"1.0.0", "1.0.a", -1},
+		{"v1.9.0", "v1.10.0", -1},
+	}
+	for _, test := range tests {
+		if got := test.a.Compare(test.b); got != test.want {
+			t.Fatalf("%v.Compare(%v): got %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+
+	vs := Versions{"1.10.0", "1.2.0", "1.9.0", "1.10.0-rc1"}
+	sort.Sort(vs)
+	for i, want := range []Version{"1.2.0", "1.9.0", "1.10.0-rc1", "1.10.0"} {
+		if vs[i] != want {
+			t.Fatalf("sorted: got %v, want %v at %d", vs, want, i)
+		}
+	}
+}